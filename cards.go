@@ -4,30 +4,40 @@ import (
 	"math/rand"
 	"time"
 	"sort"
+	"strconv"
 )
 
+// Every card in a game has its own ID, which stays the same wherever it goes,
+// so that players can say exactly which card they mean
+type Card struct {
+	id   int
+	name string
+}
+
+func (c Card) String() string {
+	return strconv.Itoa(c.id) + ":" + c.name
+}
+
 type Deck struct {
 	// The end of the slice is the top of the deck
-	cards []string
+	cards []Card
+
+	// Every card made for this game, by ID
+	lastID int
+	names  map[int]string
 }
 
-func newDeck() (d *Deck) {
-	d = new(Deck)
+func newDeck(players int) (d *Deck) {
+	d = &Deck{names: make(map[int]string)}
 
 	// Add all the cards, EXCEPT those which should not be dealt to players
-	d.insertMultiple(map[string]int{
-		"nope":    5,
-		"attack":  4,
-		"skip":    4,
-		"favour":  4,
-		"shuffle": 4,
-		"see3":    5,
-		"random1": 4,
-		"random2": 4,
-		"random3": 4,
-		"random4": 4,
-		"random5": 4,
-	})
+	cards := make(map[string]int)
+	for _, t := range cardTypes.types {
+		if t.Dealt {
+			cards[t.ID] = t.countFor(players)
+		}
+	}
+	d.insertMultiple(cards)
 
 	d.shuffle()
 
@@ -35,37 +45,65 @@ func newDeck() (d *Deck) {
 }
 
 func (d *Deck) addExtraCards(players int) {
-	d.insertMultiple(map[string]int{
-		"exploding": players - 1,
-		"defuse":    6 - players,
-	})
+	cards := make(map[string]int)
+	for _, t := range cardTypes.types {
+		if !t.Dealt {
+			cards[t.ID] = t.countFor(players)
+		}
+	}
+	d.insertMultiple(cards)
 
 	d.shuffle()
 
 	return
 }
 
-func (d *Deck) insertOnTop(card string) {
+func (d *Deck) number(hands []*Hand) {
+	// Gives every card its ID, once the deck and hands are made up. They're
+	// numbered in a random order, so an ID says nothing about what a card is
+	// or where it started
+	var cards []*Card
+	for i := range d.cards {
+		cards = append(cards, &d.cards[i])
+	}
+	for _, h := range hands {
+		for i := range h.cards {
+			cards = append(cards, &h.cards[i])
+		}
+	}
+
+	rand.Shuffle(len(cards), func(i, j int) {
+		cards[i], cards[j] = cards[j], cards[i]
+	})
+	for _, card := range cards {
+		d.lastID++
+		card.id = d.lastID
+		d.names[card.id] = card.name
+	}
+}
+
+func (d *Deck) insertOnTop(card Card) {
 	d.cards = append(d.cards, card)
 }
 
 func (d *Deck) insertMultiple(cards map[string]int) {
+	// (numbered later, along with everything else)
 	for card, number := range cards {
 		for i := 0; i < number; i++ {
-			d.insertOnTop(card)
+			d.insertOnTop(Card{name: card})
 		}
 	}
 }
 
-func (d *Deck) insertAtPos(pos int, card string) {
+func (d *Deck) insertAtPos(pos int, card Card) {
 	// the top of the deck is the end of the array
 	pos = len(d.cards) - (pos)
-	d.cards = append(d.cards, "")
+	d.cards = append(d.cards, Card{})
 	copy(d.cards[pos+1:], d.cards[pos:])
 	d.cards[pos] = card
 }
 
-func (d *Deck) draw() (card string) {
+func (d *Deck) draw() (card Card) {
 	// pop
 	card, d.cards = d.cards[len(d.cards)-1], d.cards[:len(d.cards)-1]
 	return
@@ -83,7 +121,9 @@ func (d *Deck) peek(num int) (ret []string) {
 	}
 
 	ret = make([]string, length)
-	copy(ret, d.cards[from:])
+	for i, card := range d.cards[from:] {
+		ret[i] = card.name
+	}
 
 	// Reverse
 	for i := len(ret)/2 - 1; i >= 0; i-- {
@@ -104,7 +144,11 @@ func (d *Deck) shuffle() {
 func (d *Deck) dealHand(playerCount int) (h *Hand) {
 	h = new(Hand)
 
-	h.cards = []string{"defuse"}
+	for _, t := range cardTypes.types {
+		for i := 0; i < t.Starting; i++ {
+			h.cards = append(h.cards, Card{name: t.ID})
+		}
+	}
 
 	var cardCount int
 	if playerCount > 5 {
@@ -125,22 +169,33 @@ func (d *Deck) cardsLeft() int {
 }
 
 type Hand struct {
-	cards []string
+	cards []Card
 }
 
 func (h *Hand) cardList() (list string) {
+	// As id:name pairs
 	for _, card := range h.cards {
-		list = list + " " + card
+		list = list + " " + card.String()
 	}
 
 	return
 }
 
-func (h *Hand) getCard(num int) string {
+func (h *Hand) getCard(num int) Card {
 	return h.cards[num]
 }
 
-func (h *Hand) addCard(card string) {
+func (h *Hand) find(id int) int {
+	// Finds a card by its ID, or -1 if we don't have it (any more)
+	for i, card := range h.cards {
+		if card.id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (h *Hand) addCard(card Card) {
 	h.cards = append(h.cards, card)
 }
 
@@ -148,42 +203,40 @@ func (h *Hand) removeCard(num int) {
 	h.cards = append(h.cards[:num], h.cards[num+1:]...)
 }
 
-func (h *Hand) removeByName(wanted string) {
+func (h *Hand) removeByName(wanted string) (removed Card) {
 	for i, card := range h.cards {
-		if card == wanted {
+		if card.name == wanted {
 			h.cards = append(h.cards[:i], h.cards[i+1:]...)
-			return
+			return card
 		}
 	}
+	return
 }
 
 func (h *Hand) contains(wanted string) bool {
 	for _, card := range h.cards {
-		if card == wanted {
+		if card.name == wanted {
 			return true
 		}
 	}
 	return false
 }
 
-func (h *Hand) containsMultiple(wanted string, num int) bool {
-	found := 0
-	for _, card := range h.cards {
-		if card == wanted {
-			found += 1
-			if found == num {
-				return true
-			}
+func (h *Hand) findEffect(effect string) int {
+	// Finds the first card with a given effect, or -1
+	for i, card := range h.cards {
+		if cardTypes.effect(card.name) == effect {
+			return i
 		}
 	}
-	return false
+	return -1
 }
 
 func (h *Hand) getLength() int {
 	return len(h.cards)
 }
 
-func (h *Hand) takeRandom() string {
+func (h *Hand) takeRandom() Card {
 	rand.Seed(time.Now().UnixNano())
 	cardNo := rand.Intn(len(h.cards))
 	card := h.getCard(cardNo)
@@ -192,23 +245,8 @@ func (h *Hand) takeRandom() string {
 }
 
 func (h *Hand) sort() {
-	weights := map[string]int{
-		"defuse": 10,
-		"nope": 20,
-		"skip": 30,
-		"attack": 40,
-		"see3": 50,
-		"shuffle": 60,
-		"favour": 70,
-		"random1": 80,
-		"random2": 90,
-		"random3": 100,
-		"random4": 110,
-		"random5": 120,
-	}
-
-	sort.Slice(h.cards, func (a, b int) bool {
-		return weights[h.cards[a]] < weights[h.cards[b]]
+	sort.SliceStable(h.cards, func (a, b int) bool {
+		return cardTypes.get(h.cards[a].name).Weight < cardTypes.get(h.cards[b].name).Weight
 	})
 }
 
@@ -217,25 +255,28 @@ type GameState struct {
 	// needs to contain anything that can be reversed by a NOPE
 	deck          Deck
 	currentPlayer int
-	attack        bool
+	turnsOwed     int
 }
 
 func makeGameState(g *Game) *GameState {
 	// Copy everything
-	cards := make([]string, len(g.deck.cards))
+	cards := make([]Card, len(g.deck.cards))
 	copy(cards, g.deck.cards)
 
 	return &GameState{
 		deck: Deck{
 			cards: cards,
+			// No new cards are made once the game has started
+			lastID: g.deck.lastID,
+			names:  g.deck.names,
 		},
 		currentPlayer: g.currentPlayer,
-		attack:        g.attack,
+		turnsOwed:     g.turnsOwed,
 	}
 }
 
 func (gs *GameState) restore(g *Game) {
 	g.deck = &gs.deck
 	g.currentPlayer = gs.currentPlayer
-	g.attack = gs.attack
+	g.turnsOwed = gs.turnsOwed
 }
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"strings"
+)
+
+// Chat commands, as typed into the chat box (the client sends anything
+// starting with "/" without the slash):
+//
+//	chat <text>       everyone in the lobby
+//	me <text>         an action, e.g. "/me shuffles nervously"
+//	w <name> <text>   a whisper, just to one person
+//	s <text>          spectators only
+//	p <text>          players only
+//	mute <name>       stop seeing someone's chat (just for you)
+//	unmute <name>
+//	muteall           (host only) nobody else can chat
+//	unmuteall
+//	help
+//
+// Anything that can't be done is answered with "notice <reason> [...]".
+
+// Everything that counts against the chat rate limit
+var chatCommands = map[string]bool{
+	"chat": true,
+	"me":   true,
+	"w":    true,
+	"s":    true,
+	"p":    true,
+}
+
+func isChat(msg string) bool {
+	return chatCommands[strings.SplitN(msg, " ", 2)[0]]
+}
+
+func (l *Lobby) readChat(c *Client, fields []string, msg string) bool {
+	// Returns true if the message was a chat command
+	text := ""
+	if len(fields) > 1 {
+		text = strings.TrimSpace(msg[len(fields[0]):])
+	}
+
+	switch fields[0] {
+	case "chat", "me", "s", "p":
+		if fields[0] != "s" && l.isPeeking(c) {
+			// They know too much to talk to the players
+			c.sendMsg("notice peeking_chat")
+			break
+		}
+		if !l.canChat(c, text) {
+			break
+		}
+		text = filterChat(text)
+
+		switch fields[0] {
+		case "chat":
+			l.sendChat(c, "chat "+c.name+" "+text, nil, true)
+		case "me":
+			l.sendChat(c, "chat_me "+c.name+" "+text, nil, true)
+		case "s", "p":
+			// Players and spectators can only talk amongst themselves
+			spectating := l.currentGame.spectators[c]
+			if spectating != (fields[0] == "s") {
+				c.sendMsg("notice wrong_channel")
+				break
+			}
+			channel := "players"
+			if spectating {
+				channel = "spectators"
+			}
+			l.sendChat(c, "chat_team "+channel+" "+c.name+" "+text, func(to *Client) bool {
+				return l.currentGame.spectators[to] == spectating
+			}, false)
+		}
+
+	case "w":
+		if len(fields) < 3 {
+			c.sendMsg("notice usage_w")
+			break
+		}
+		text = strings.TrimSpace(text[len(fields[1]):])
+		if !l.canChat(c, text) {
+			break
+		}
+
+		target := l.clientByName(fields[1])
+		if target == nil {
+			c.sendMsg("notice no_such_player " + fields[1])
+			break
+		}
+		if !l.currentGame.spectators[target] && l.isPeeking(c) {
+			c.sendMsg("notice peeking_chat")
+			break
+		}
+
+		l.sendChat(c, "whisper "+c.name+" "+target.name+" "+filterChat(text), func(to *Client) bool {
+			return to == c || to == target
+		}, false)
+
+	case "mute", "unmute":
+		if len(fields) != 2 || fields[1] == c.name {
+			c.sendMsg("notice usage_" + fields[0])
+			break
+		}
+
+		l.clientsMu.Lock()
+		if fields[0] == "mute" {
+			if c.muted == nil {
+				c.muted = make(map[string]bool)
+			}
+			c.muted[fields[1]] = true
+		} else {
+			delete(c.muted, fields[1])
+		}
+		l.clientsMu.Unlock()
+
+		c.sendMsg("notice " + fields[0] + "d " + fields[1])
+
+	case "muteall", "unmuteall":
+		l.clientsMu.Lock()
+		defer l.clientsMu.Unlock()
+
+		if c != l.host {
+			c.sendMsg("notice not_host")
+			break
+		}
+
+		l.chatMuted = fields[0] == "muteall"
+		l.sendBcastRaw("notice " + fields[0] + " " + c.name)
+
+	case "help":
+		c.sendMsg("notice help")
+
+	default:
+		return false
+	}
+
+	return true
+}
+
+func (l *Lobby) canChat(c *Client, text string) bool {
+	if text == "" {
+		return false
+	}
+
+	if max := conf().Lobby.MaxChatLength; max > 0 && len(text) > max {
+		c.sendMsg("notice too_long")
+		return false
+	}
+
+	l.clientsMu.Lock()
+	muted := l.chatMuted && c != l.host
+	l.clientsMu.Unlock()
+
+	if muted {
+		c.sendMsg("notice chat_muted")
+		return false
+	}
+	return true
+}
+
+func (l *Lobby) sendChat(from *Client, msg string, to func(*Client) bool, public bool) {
+	// Like sendBcast, but leaves out anyone who has muted the sender,
+	// and anyone not in the audience (if given)
+	l.clientsMu.Lock()
+	defer l.clientsMu.Unlock()
+
+	if public {
+		l.chatHistory.add(msg)
+	}
+
+	for client := range l.clients {
+		if to != nil && !to(client) {
+			continue
+		}
+		if client.muted[from.name] {
+			continue
+		}
+		client.sendMsg(msg)
+	}
+}
+
+func (l *Lobby) clientByName(name string) *Client {
+	l.clientsMu.Lock()
+	defer l.clientsMu.Unlock()
+
+	for client := range l.clients {
+		if client.name == name {
+			return client
+		}
+	}
+	return nil
+}
+
+func (l *Lobby) chooseHost() {
+	// Whoever made the lobby is in charge of it, until they leave
+	// /!\ needs a lock on l.clients
+	if l.host != nil && l.clients[l.host] {
+		return
+	}
+
+	l.host = nil
+	for client := range l.clients {
+		l.host = client
+		break
+	}
+
+	if l.host != nil {
+		l.sendBcastRaw("host " + l.host.name)
+	}
+}
+
+func filterChat(text string) string {
+	// Blanks out any words from the filter list
+	words := conf().Lobby.FilteredWords
+	if len(words) == 0 {
+		return text
+	}
+
+	fields := strings.Fields(text)
+	for i, field := range fields {
+		bare := strings.ToLower(strings.Trim(field, ".,!?;:'\"()*-_"))
+		for _, word := range words {
+			if bare != "" && bare == strings.ToLower(word) {
+				fields[i] = strings.Repeat("*", len(field))
+				break
+			}
+		}
+	}
+	return strings.Join(fields, " ")
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"runtime/debug"
@@ -11,41 +12,61 @@ import (
 	"github.com/gorilla/websocket"
 )
 
-const (
-	// Timeout for writing to a client
-	writeWait = 10 * time.Second
-
-	// Timeout for receiving a 'pong' from the client
-	pongWait = 15 * time.Second
-
-	// How often should we ping? Must be less than pongWait
-	pingPeriod = (pongWait * 9) / 10
-
-	maxMessageSize = 512
-)
+// A connection to a client, over a websocket or otherwise (see sse.go)
+type connection interface {
+	Close() error
+}
 
 type Client struct {
-	// Websocket connection object
-	conn *websocket.Conn
+	// Websocket (or other) connection object
+	conn connection
 
 	// Buffer for outgoing messages
 	send chan []byte
 
 	name  string
 	lobby *Lobby
+
+	// Where the client is really connecting from, even behind a proxy
+	addr string
+
+	id uint64
+
+	// Numbering messages and picking up dropped connections (see session.go)
+	// conn, send and everything below are protected by sendMu
+	sendMu    sync.Mutex
+	seq       uint64   // The last message sent
+	unacked   []string // Sent messages the client hasn't acknowledged yet
+	forgotten uint64   // The last message we're no longer keeping
+	token     string
+	detached  bool // The connection has gone, but they might come back
+	gone      bool // Finished with for good
+	grace     *time.Timer
+
+	// Flood protection (see ratelimit.go)
+	limits rateLimiter
+	kicked bool
+
+	// People whose chat this client doesn't want to see (protected by
+	// the lobby's clientsMu)
+	muted map[string]bool
 }
 
-func (c *Client) readPump(lobbies map[string]*Lobby) {
+func (c *Client) readPump(conn *websocket.Conn, lobbies map[string]*Lobby) {
 	// Sets up a client, reads incoming messages and sends them to the right place
 	//
 	// This is called as a goroutine for each client, and this function
 	// is the only function allowed to read from the client.
+	//
+	// The connection might end up belonging to another client, if it resumes
+	// a session
+	addr := c.addr
 
 	defer func() {
 		// Clean up
-		c.conn.Close()
+		conn.Close()
 		if c.lobby != nil {
-			c.lobby.unregister <- c
+			c.disconnected(conn)
 		}
 
 		if r := recover(); r != nil {
@@ -53,10 +74,17 @@ func (c *Client) readPump(lobbies map[string]*Lobby) {
 		}
 	}()
 
-	c.conn.SetReadLimit(maxMessageSize)
-	c.conn.SetReadDeadline(time.Now().Add(pongWait))
-	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	if connectFrom(addr) {
+		defer disconnectFrom(addr)
+	} else {
+		c.kick("too_many_connections")
+	}
+
+	// Timeouts and limits come from the settings (see config.go)
+	conn.SetReadLimit(conf().Websocket.MaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(conf().Websocket.PongWait.Duration))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(conf().Websocket.PongWait.Duration))
 		return nil
 	})
 
@@ -64,55 +92,105 @@ func (c *Client) readPump(lobbies map[string]*Lobby) {
 
 		// Read the incoming messages
 
-		_, bytes, err := c.conn.ReadMessage()
+		_, bytes, err := conn.ReadMessage()
 		if err != nil {
 			// The connection is dead
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("%s !!! Connection closed (%v)", c.name, err)
+				c.log().Info("Connection closed", "addr", c.addr, "err", err)
 			}
 			break
 		}
 
-		message := string(bytes)
+		c = c.received(string(bytes), lobbies)
+	}
+}
 
-		// Check for badly-formed messages which could do something strange
-		if strings.Contains(message, "\n") || strings.Contains(message, "\r") {
-			continue
-		}
+func (c *Client) received(message string, lobbies map[string]*Lobby) *Client {
+	// Deals with one incoming message, whichever way it came in. Returns the
+	// client the connection belongs to afterwards, which changes if it
+	// resumes a session
+
+	// Check for badly-formed messages which could do something strange,
+	// including to the terminals of anyone playing over TCP
+	if strings.ContainsFunc(message, isControl) {
+		return c
+	}
 
-		log.Printf("%s >>> %s", c.name, message)
+	if c.kicked {
+		// Just waiting for the connection to close
+		return c
+	}
+	if !c.allowed(message) {
+		c.kick("flood")
+		return c
+	}
 
-		// If this client is in a lobby, let the lobby handle the message
+	c.logMessage("recv", message)
 
-		if c.lobby != nil {
-			c.lobby.readFromClient(c, message)
-			continue
+	fields := strings.Fields(message)
+
+	// Keeping the connection in order
+	if len(fields) == 2 && fields[0] == "ack" {
+		if seq, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			c.ack(seq)
+		}
+		return c
+	}
+	if len(fields) == 3 && fields[0] == "resend" {
+		from, err1 := strconv.ParseUint(fields[1], 10, 64)
+		to, err2 := strconv.ParseUint(fields[2], 10, 64)
+		if err1 == nil && err2 == nil {
+			c.resend(from, to)
 		}
+		return c
+	}
 
-		// The client is not currently in a lobby; check if they're trying to join
+	// If this client is in a lobby, let the lobby handle the message
 
-		fields := strings.Fields(message)
+	if c.lobby != nil {
+		c.lobby.readFromClient(c, message)
+		return c
+	}
 
-		if len(fields) == 3 && fields[0] == "join_lobby" {
-			lobby_name := fields[1]
-			player_name := fields[2]
+	// The client is not currently in a lobby; check if they're trying to join
 
-			// Length is already limited by SetReadLimit, so we're not worried
+	if len(fields) == 3 && fields[0] == "join_lobby" {
+		lobby_name := fields[1]
+		player_name := fields[2]
 
-			c.joinToLobby(lobby_name, player_name, lobbies)
+		// Length is already limited by max_message_size, so we're not worried
+
+		c.joinToLobby(lobby_name, player_name, lobbies)
+	}
+
+	// ... or get back into one after losing their connection
+	if len(fields) == 3 && fields[0] == "resume" {
+		old := findSession(fields[1])
+		last, err := strconv.ParseUint(fields[2], 10, 64)
+		if old == nil || err != nil || !old.resume(c, last) {
+			c.sendMsg("err session_expired")
+			return c
 		}
+
+		// From now on, this connection belongs to the old client
+		return old
 	}
+
+	return c
 }
 
-func (c *Client) writePump() {
+func (c *Client) writePump(conn *websocket.Conn, send chan []byte) {
 	// Counterpart to readPump
 	// Massively adapted from the gorilla websocket docs
+	//
+	// We're given the connection and channel to use, because if the client
+	// reconnects, c.conn and c.send will be replaced
 
-	ticker := time.NewTicker(pingPeriod)
+	ticker := time.NewTicker(conf().pingPeriod())
 
 	defer func() {
 		ticker.Stop()
-		c.conn.Close()
+		conn.Close()
 
 		if r := recover(); r != nil {
 			c.dieGracefully(r)
@@ -124,21 +202,21 @@ func (c *Client) writePump() {
 
 	for {
 		select {
-		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+		case message, ok := <-send:
+			conn.SetWriteDeadline(time.Now().Add(conf().Websocket.WriteWait.Duration))
 
 			if !ok {
-				log.Printf("%s !!! Write channel closed", c.name)
+				c.log().Debug("Write channel closed")
 
 				// Close the channel
 				// I have no idea how this actually works
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
 
-			w, err := c.conn.NextWriter(websocket.TextMessage)
+			w, err := conn.NextWriter(websocket.TextMessage)
 			if err != nil {
-				log.Printf("%s !!! Disconnected on write (%v)", c.name, err)
+				c.log().Info("Disconnected on write", "err", err)
 				return
 			}
 			w.Write(message)
@@ -153,14 +231,14 @@ func (c *Client) writePump() {
 			*/
 
 			if err := w.Close(); err != nil {
-				log.Printf("%s !!! Couldn't close write (%v)", c.name, err)
+				c.log().Info("Couldn't close write", "err", err)
 				return
 			}
 
 		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				log.Printf("%s !!! Ping timeout (%v)", c.name, err)
+			conn.SetWriteDeadline(time.Now().Add(conf().Websocket.WriteWait.Duration))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.log().Info("Ping timeout", "err", err)
 				return
 			}
 		}
@@ -168,10 +246,22 @@ func (c *Client) writePump() {
 }
 
 func (c *Client) sendMsg(message string) {
-	log.Printf("%s <<< %s", c.name, message)
+	c.logMessage("send", message)
+
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	c.seq++
+	line := strconv.FormatUint(c.seq, 10) + " " + message
+	c.keep(line)
+
+	if c.send == nil {
+		// Nobody to send it to right now; it's kept in case they come back
+		return
+	}
 
 	select {
-	case c.send <- []byte(message):
+	case c.send <- []byte(line):
 	default:
 		log.Fatal("Failed to write to client", c.name)
 	}
@@ -179,6 +269,10 @@ func (c *Client) sendMsg(message string) {
 
 func (c *Client) dieGracefully(r interface {}) {
 	// Terminates a panicking client to avoid crashing the server
-	log.Printf("!!! PANIC in client %s: %v !!!", c.name, r)
-	debug.PrintStack()
+	c.log().Error("PANIC in client", "panic", r, "stack", string(debug.Stack()))
+}
+
+func isControl(r rune) bool {
+	// Characters that do things to a terminal, rather than being shown
+	return r < 0x20 || r == 0x7f || (r >= 0x80 && r < 0xa0)
 }
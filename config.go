@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Settings come from, in increasing order of importance: the defaults below,
+// the JSON file given with -config, environment variables and command-line
+// flags. Environment variables are named after the setting, e.g.
+// WWWCATS_WEBSOCKET_PONG_WAIT=20s or WWWCATS_GAME_MAX_PLAYERS=5.
+//
+// On SIGHUP the settings are loaded again. Anything that only affects new
+// connections, lobbies or games takes effect straight away; everything else
+// needs a restart.
+
+type Config struct {
+	Server struct {
+		Listen    string `json:"listen"`
+		StaticDir string `json:"static_dir"`
+		Cards     string `json:"cards"`
+
+		// Serve HTTPS (and WSS) if these are set. The files are
+		// checked for changes every few seconds.
+		TLSCert string `json:"tls_cert"`
+		TLSKey  string `json:"tls_key"`
+
+		// Plain HTTP address which redirects everyone to HTTPS
+		RedirectListen string `json:"redirect_listen"`
+
+		// Address for playing over plain TCP, from a terminal (see tcp.go);
+		// off if empty
+		TCPListen string `json:"tcp_listen"`
+
+		// Serve everything under this path, e.g. "/cats", when a proxy
+		// passes on a sub-path
+		BasePath string `json:"base_path"`
+
+		// Websites allowed to connect to /ws, e.g. "https://example.com",
+		// or "*" for anyone. By default, only our own pages can.
+		AllowedOrigins []string `json:"allowed_origins"`
+
+		// Addresses or CIDR ranges of reverse proxies whose
+		// X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host we believe
+		TrustedProxies []string `json:"trusted_proxies"`
+	} `json:"server" reload:"false"`
+
+	Websocket struct {
+		// Timeout for writing to a client
+		WriteWait Duration `json:"write_wait"`
+
+		// Timeout for receiving a 'pong' from the client; we ping a bit more often than this
+		PongWait Duration `json:"pong_wait"`
+
+		MaxMessageSize int64 `json:"max_message_size"`
+
+		// Buffer for outgoing messages to each client
+		SendBuffer int `json:"send_buffer"`
+
+		// How many unacknowledged messages to keep for each client, in case
+		// they need sending again; any further behind and the client gets
+		// the whole game state instead
+		ResendBuffer int `json:"resend_buffer"`
+
+		// How long to keep someone's place after their connection drops
+		// (0 = don't)
+		ReconnectGrace Duration `json:"reconnect_grace"`
+
+		ReadBufferSize  int `json:"read_buffer_size" reload:"false"`
+		WriteBufferSize int `json:"write_buffer_size" reload:"false"`
+	} `json:"websocket"`
+
+	Lobby struct {
+		// 0 means no limit
+		MaxLobbies int `json:"max_lobbies"`
+		MaxClients int `json:"max_clients"`
+
+		// How many chat messages to show people when they join
+		ChatHistory int `json:"chat_history"`
+
+		// Longest chat message allowed (0 for any length), and words to
+		// blank out of chat
+		MaxChatLength int      `json:"max_chat_length"`
+		FilteredWords []string `json:"filtered_words"`
+
+		// Whether spectators can choose to see everyone's cards, to begin
+		// with; the host can change it
+		AllowPeeking bool `json:"allow_peeking"`
+
+		// How far behind the players spectators see a game in progress, for
+		// streaming (0 = not at all)
+		SpectatorDelay Duration `json:"spectator_delay"`
+	} `json:"lobby"`
+
+	Limits struct {
+		// Messages per second from each connection, and how many can come in a
+		// burst; chat has its own, lower, limit too. 0 means no limit.
+		MessageRate  float64 `json:"message_rate"`
+		MessageBurst int     `json:"message_burst"`
+		ChatRate     float64 `json:"chat_rate"`
+		ChatBurst    int     `json:"chat_burst"`
+
+		// Everyone on the same address shares this many times the above
+		AddrMultiplier float64 `json:"addr_multiplier"`
+
+		// New lobbies per second from each address
+		LobbyRate  float64 `json:"lobby_rate"`
+		LobbyBurst int     `json:"lobby_burst"`
+
+		// At once, from each address (0 = no limit)
+		MaxConnectionsPerAddr int `json:"max_connections_per_addr"`
+		MaxLobbiesPerAddr     int `json:"max_lobbies_per_addr"`
+	} `json:"limits"`
+
+	Game struct {
+		MinPlayers int `json:"min_players"`
+		MaxPlayers int `json:"max_players"`
+
+		// Warn everyone when a game starts with at least this many players (0 = never)
+		HighPlayers int `json:"high_players"`
+
+		// How long the winner gets to gloat before the next game is set up
+		NewGameDelay Duration `json:"new_game_delay"`
+
+		// How many recent moves to show people who join in the middle of a game
+		ActionHistory int `json:"action_history"`
+
+		// Points for each placing, winner first; anyone further down gets nothing
+		Points []int `json:"points"`
+
+		// Games in a match (0 = keep going), and whether the same players sit
+		// down again for each game; the host can change both in their lobby
+		SeriesLength int  `json:"series_length"`
+		KeepSeats    bool `json:"keep_seats"`
+
+		// How long to count down once everyone is ready, and whether to sit
+		// spectators down by themselves when there's room
+		StartCountdown Duration `json:"start_countdown"`
+		AutoFill       bool     `json:"auto_fill"`
+
+		// How long a vote stays open before it's given up on
+		VoteTimeout Duration `json:"vote_timeout"`
+	} `json:"game"`
+
+	Tournament struct {
+		// Most players at a table, and how many from each table go through
+		// to the next round
+		TableSize int `json:"table_size"`
+		Advance   int `json:"advance"`
+
+		// How long to wait for everyone to sit down at their table before
+		// starting without them
+		NoShowWait Duration `json:"no_show_wait"`
+	} `json:"tournament"`
+
+	Log struct {
+		// debug, info, warn or error
+		Level string `json:"level"`
+
+		// text (key=value) or json
+		Format string `json:"format" reload:"false"`
+
+		// Log to this file instead of stderr, moving it aside once it reaches
+		// max_size megabytes (0 = never) and keeping max_files old ones
+		File     string `json:"file" reload:"false"`
+		MaxSize  int64  `json:"max_size" reload:"false"`
+		MaxFiles int    `json:"max_files" reload:"false"`
+
+		// Lobbies to log every message for, without hiding anything
+		TraceLobbies []string `json:"trace_lobbies"`
+	} `json:"log"`
+}
+
+func defaultConfig() *Config {
+	c := new(Config)
+
+	c.Server.Listen = ":8080"
+
+	c.Websocket.WriteWait = Duration{10 * time.Second}
+	c.Websocket.PongWait = Duration{15 * time.Second}
+	c.Websocket.MaxMessageSize = 512
+	c.Websocket.SendBuffer = 256
+	c.Websocket.ResendBuffer = 200
+	c.Websocket.ReconnectGrace = Duration{30 * time.Second}
+	c.Websocket.ReadBufferSize = 1024
+	c.Websocket.WriteBufferSize = 1024
+
+	c.Lobby.ChatHistory = 50
+	c.Lobby.MaxChatLength = 300
+	c.Lobby.AllowPeeking = false
+
+	c.Limits.MessageRate = 10
+	c.Limits.MessageBurst = 30
+	c.Limits.ChatRate = 1
+	c.Limits.ChatBurst = 5
+	c.Limits.AddrMultiplier = 3
+	c.Limits.LobbyRate = 0.1
+	c.Limits.LobbyBurst = 3
+	c.Limits.MaxConnectionsPerAddr = 20
+	c.Limits.MaxLobbiesPerAddr = 5
+
+	c.Game.MinPlayers = 2
+	c.Game.MaxPlayers = 6
+	c.Game.HighPlayers = 6
+	c.Game.NewGameDelay = Duration{5 * time.Second}
+	c.Game.ActionHistory = 30
+	c.Game.Points = []int{3, 2, 1}
+	c.Game.SeriesLength = 0
+	c.Game.KeepSeats = false
+	c.Game.StartCountdown = Duration{5 * time.Second}
+	c.Game.AutoFill = false
+	c.Game.VoteTimeout = Duration{time.Minute}
+
+	c.Tournament.TableSize = 4
+	c.Tournament.Advance = 1
+	c.Tournament.NoShowWait = Duration{2 * time.Minute}
+
+	c.Log.Level = "info"
+	c.Log.Format = "text"
+	c.Log.MaxSize = 100
+	c.Log.MaxFiles = 5
+
+	return c
+}
+
+var config atomic.Value
+
+func init() {
+	config.Store(defaultConfig())
+}
+
+// The settings currently in force. Don't hang on to the result for long,
+// or a reload will pass you by.
+func conf() *Config {
+	return config.Load().(*Config)
+}
+
+func (c *Config) pingPeriod() time.Duration {
+	// Must be less than PongWait
+	return c.Websocket.PongWait.Duration * 9 / 10
+}
+
+func loadConfig(path string) (*Config, error) {
+	c := defaultConfig()
+
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(c); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	if err := applyEnv(reflect.ValueOf(c).Elem(), "WWWCATS"); err != nil {
+		return nil, err
+	}
+
+	// Flags given on the command line win
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "l":
+			c.Server.Listen = *addr
+		case "static":
+			c.Server.StaticDir = *staticDir
+		case "cards":
+			c.Server.Cards = *cardsPath
+		}
+	})
+
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func applyEnv(v reflect.Value, prefix string) error {
+	// Overrides settings with WWWCATS_SECTION_NAME environment variables
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := prefix + "_" + strings.ToUpper(strings.Split(field.Tag.Get("json"), ",")[0])
+		value := v.Field(i)
+
+		if value.Kind() == reflect.Struct && field.Type != reflect.TypeOf(Duration{}) {
+			if err := applyEnv(value, name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		env, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		var err error
+		switch value.Kind() {
+		case reflect.String:
+			value.SetString(env)
+		case reflect.Slice:
+			// Comma-separated lists
+			var list []string
+			for _, item := range strings.Split(env, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+			value.Set(reflect.ValueOf(list))
+		case reflect.Struct:
+			var d time.Duration
+			d, err = time.ParseDuration(env)
+			value.Set(reflect.ValueOf(Duration{d}))
+		default:
+			err = json.Unmarshal([]byte(env), value.Addr().Interface())
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) check() error {
+	// Catch anything that would break the server later on
+	ws := c.Websocket
+
+	c.Server.BasePath = strings.TrimRight(c.Server.BasePath, "/")
+	if _, err := parseNetworks(c.Server.TrustedProxies); err != nil {
+		return fmt.Errorf("server.trusted_proxies: %v", err)
+	}
+
+	switch {
+	case c.Server.Listen == "":
+		return errors.New("server.listen must be set")
+	case (c.Server.TLSCert == "") != (c.Server.TLSKey == ""):
+		return errors.New("server.tls_cert and server.tls_key must be set together")
+	case c.Server.RedirectListen != "" && c.Server.TLSCert == "":
+		return errors.New("server.redirect_listen only makes sense with server.tls_cert")
+	case c.Server.BasePath != "" && !strings.HasPrefix(c.Server.BasePath, "/"):
+		return errors.New(`server.base_path must start with "/"`)
+	case ws.WriteWait.Duration <= 0 || ws.PongWait.Duration <= 0:
+		return errors.New("websocket timeouts must be positive")
+	case ws.MaxMessageSize < 64:
+		return errors.New("websocket.max_message_size must be at least 64")
+	case ws.SendBuffer < 1 || ws.ReadBufferSize < 1 || ws.WriteBufferSize < 1:
+		return errors.New("websocket buffer sizes must be positive")
+	case ws.ResendBuffer < 0 || ws.ReconnectGrace.Duration < 0:
+		return errors.New("websocket.resend_buffer and websocket.reconnect_grace can't be negative")
+	case c.Lobby.MaxLobbies < 0 || c.Lobby.MaxClients < 0:
+		return errors.New("lobby limits can't be negative")
+	case c.Lobby.ChatHistory < 0 || c.Game.ActionHistory < 0:
+		return errors.New("history lengths can't be negative")
+	case c.Lobby.MaxChatLength < 0:
+		return errors.New("lobby max_chat_length can't be negative")
+	case c.Lobby.SpectatorDelay.Duration < 0:
+		return errors.New("lobby spectator_delay can't be negative")
+	case c.Limits.MessageRate < 0 || c.Limits.ChatRate < 0 || c.Limits.LobbyRate < 0:
+		return errors.New("limits rates can't be negative")
+	case c.Limits.MessageRate > 0 && c.Limits.MessageBurst < 1,
+		c.Limits.ChatRate > 0 && c.Limits.ChatBurst < 1,
+		c.Limits.LobbyRate > 0 && c.Limits.LobbyBurst < 1:
+		return errors.New("limits bursts must be at least 1")
+	case c.Limits.AddrMultiplier < 1:
+		return errors.New("limits.addr_multiplier must be at least 1")
+	case c.Limits.MaxConnectionsPerAddr < 0 || c.Limits.MaxLobbiesPerAddr < 0:
+		return errors.New("limits per address can't be negative")
+	case c.Tournament.TableSize < 2 || c.Tournament.TableSize > c.Game.MaxPlayers:
+		return errors.New("tournament.table_size must be between 2 and game.max_players")
+	case c.Tournament.Advance < 1:
+		return errors.New("tournament.advance must be at least 1")
+	case c.Tournament.NoShowWait.Duration < time.Second:
+		return errors.New("tournament.no_show_wait must be at least a second")
+	case c.Game.VoteTimeout.Duration <= 0:
+		return errors.New("game.vote_timeout must be positive")
+	case c.Game.StartCountdown.Duration < 0:
+		return errors.New("game.start_countdown can't be negative")
+	case c.Game.SeriesLength < 0:
+		return errors.New("game.series_length can't be negative")
+	case c.Game.MinPlayers < 2:
+		return errors.New("game.min_players must be at least 2")
+	case c.Game.MaxPlayers < c.Game.MinPlayers:
+		return errors.New("game.max_players must be at least game.min_players")
+	case c.Game.NewGameDelay.Duration < 0:
+		return errors.New("game.new_game_delay can't be negative")
+	case c.Log.Format != "text" && c.Log.Format != "json":
+		return errors.New(`log.format must be "text" or "json"`)
+	case c.Log.MaxSize < 0 || c.Log.MaxFiles < 0:
+		return errors.New("log.max_size and log.max_files can't be negative")
+	}
+
+	if _, err := parseLevel(c.Log.Level); err != nil {
+		return fmt.Errorf("log.level: %v", err)
+	}
+
+	return nil
+}
+
+func (c *Config) checkCards(registry *CardRegistry) error {
+	// Every game needs something to explode
+	for players := c.Game.MinPlayers; players <= c.Game.MaxPlayers; players++ {
+		found := false
+		for _, t := range registry.types {
+			if t.Effect == effectExploding && t.countFor(players) > 0 {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("the deck has nothing to explode with %d players", players)
+		}
+	}
+	return nil
+}
+
+func reloadOnHangup(path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		c, err := loadConfig(path)
+		if err == nil {
+			err = c.checkCards(cardTypes)
+		}
+		if err != nil {
+			slog.Error("Not reloading settings", "err", err)
+			continue
+		}
+
+		old := conf()
+		for _, name := range restartNeeded(reflect.ValueOf(old).Elem(), reflect.ValueOf(c).Elem(), "") {
+			slog.Warn("Setting has changed, but needs a restart to take effect", "setting", name)
+		}
+		keepUnreloadable(reflect.ValueOf(old).Elem(), reflect.ValueOf(c).Elem())
+
+		config.Store(c)
+		level, _ := parseLevel(c.Log.Level)
+		logLevel.Set(level)
+		slog.Info("Settings reloaded")
+	}
+}
+
+func restartNeeded(old, new reflect.Value, prefix string) (names []string) {
+	t := old.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := prefix + strings.Split(field.Tag.Get("json"), ",")[0]
+
+		if field.Tag.Get("reload") == "false" {
+			if !reflect.DeepEqual(old.Field(i).Interface(), new.Field(i).Interface()) {
+				names = append(names, name)
+			}
+		} else if field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(Duration{}) {
+			names = append(names, restartNeeded(old.Field(i), new.Field(i), name+".")...)
+		}
+	}
+	return
+}
+
+func keepUnreloadable(old, new reflect.Value) {
+	// Undoes changes to settings that can't change while we're running
+	t := old.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		if field.Tag.Get("reload") == "false" {
+			new.Field(i).Set(old.Field(i))
+		} else if field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(Duration{}) {
+			keepUnreloadable(old.Field(i), new.Field(i))
+		}
+	}
+}
+
+// A time.Duration which is written as "10s" etc. in the config file
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) (err error) {
+	var s string
+	if err = json.Unmarshal(data, &s); err != nil {
+		return errors.New(`durations should look like "10s"`)
+	}
+	d.Duration, err = time.ParseDuration(s)
+	return
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"time"
+)
+
+// A delay on what spectators see, for streaming games without the audience
+// being able to tell the players anything useful. With lobby.spectator_delay
+// set, broadcasts reach anyone watching a game in progress (including anyone
+// who has exploded, or is peeking) that much later than the players, and so
+// does the game state sent to anyone who joins part way through. Chat isn't
+// held back.
+
+// Anything that can be sent messages, e.g. a Client
+type listener interface {
+	sendMsg(msg string)
+}
+
+// Lines waiting to go out to one listener, oldest first
+type feed struct {
+	lines []delayedLine
+	timer *time.Timer
+}
+
+type delayedLine struct {
+	at  time.Time
+	msg string
+}
+
+func (l *Lobby) sendDelayed(to listener, msg string) {
+	// Sends a spectator a message once the delay is up, or straight away if
+	// it doesn't apply to them
+	// /!\ needs a lock on l.clients
+	f := l.delayed[to]
+
+	if f == nil && !l.delaying(to) {
+		to.sendMsg(msg)
+		return
+	}
+
+	if f == nil {
+		f = &feed{}
+		l.delayed[to] = f
+	}
+
+	at := time.Now()
+	if l.delaying(to) {
+		at = at.Add(conf().Lobby.SpectatorDelay.Duration)
+	}
+	if n := len(f.lines); n > 0 && f.lines[n-1].at.After(at) {
+		// Never overtake what's already waiting
+		at = f.lines[n-1].at
+	}
+	f.lines = append(f.lines, delayedLine{at, msg})
+
+	if f.timer == nil {
+		f.timer = time.AfterFunc(time.Until(at), func() {
+			l.releaseDelayed(to)
+		})
+	}
+}
+
+func (l *Lobby) delaying(to listener) bool {
+	// Anyone not playing counts as a spectator
+	// /!\ needs a lock on l.clients
+	g := l.currentGame
+	if conf().Lobby.SpectatorDelay.Duration <= 0 || !g.started {
+		return false
+	}
+	client, ok := to.(*Client)
+	return !ok || g.playerNumber(client) == -1
+}
+
+func (l *Lobby) sender(to listener) func(string) {
+	// For sending a run of messages, delayed if need be
+	// /!\ needs a lock on l.clients
+	return func(msg string) {
+		l.sendDelayed(to, msg)
+	}
+}
+
+func (l *Lobby) releaseDelayed(to listener) {
+	// Sends whatever is due, and waits for the rest
+	l.clientsMu.Lock()
+	defer l.clientsMu.Unlock()
+
+	f := l.delayed[to]
+	if f == nil {
+		return
+	}
+
+	now := time.Now()
+	for len(f.lines) > 0 && !f.lines[0].at.After(now) {
+		to.sendMsg(f.lines[0].msg)
+		f.lines = f.lines[1:]
+	}
+
+	if len(f.lines) == 0 {
+		delete(l.delayed, to)
+		return
+	}
+	f.timer.Reset(time.Until(f.lines[0].at))
+}
+
+func (l *Lobby) flushDelayed(client *Client) {
+	// Sends everything that's waiting straight away, e.g. when a spectator
+	// sits down between games and there's nothing left to hide
+	// /!\ needs a lock on l.clients
+	f := l.delayed[client]
+	if f == nil {
+		return
+	}
+
+	f.timer.Stop()
+	for _, line := range f.lines {
+		client.sendMsg(line.msg)
+	}
+	delete(l.delayed, client)
+}
+
+func (l *Lobby) dropDelayed(to listener) {
+	// Forgets about someone who has gone
+	// /!\ needs a lock on l.clients
+	if f := l.delayed[to]; f != nil {
+		f.timer.Stop()
+		delete(l.delayed, to)
+	}
+}
@@ -2,13 +2,19 @@ package main
 
 import (
 	"log"
+	"sync/atomic"
 	"math/rand"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// For telling games apart in the logs
+var lastGameID uint64
+
 type Game struct {
+	id uint64
+
 	// The corresponding Lobby object, to allow communication
 	// with clients
 	lobby *Lobby
@@ -26,7 +32,9 @@ type Game struct {
 	// Various game-related variables
 	started   bool
 	defusing  bool
-	attack    bool
+	defusingCard Card // Which card is being defused
+	defusePlayed bool // ... and whether they've played their defuse yet
+	turnsOwed int // How many turns the current player has left, including this one
 	favouring *Client // Who is asking for a favour?
 	favoured  *Client // Who is being asked for a favour?
 	favourType int // !! not reset !!
@@ -37,14 +45,48 @@ type Game struct {
 
 	// We store one action's worth of 'history' in case of a nope
 	history *GameState
+
+	// For catching up people who join mid-game
+	actions    *ringBuffer // Recent public events (protected by lobby.clientsMu)
+	discard    string      // The card on top of the discard pile
+	knockedOut []string    // Players who have exploded, in order
+
+	// Deciding things together (see vote.go)
+	paused bool
+	vote   *vote
+	voteID uint64 // Which vote is the current one
+
+	// Getting the game started (see ready.go)
+	ready         map[*Client]bool
+	sittingOut    map[*Client]bool // Spectators who'd rather not be sat down
+	// (protected by lobby.clientsMu)
+	countdown     *time.Timer
+	countdownID   uint64 // Which countdown is the current one
+	countdownEnds time.Time
+
+	// The IDs of every card each player has had in their hand, which are
+	// the only ones they can ask for by ID when stealing
+	held map[*Client]map[int]bool
+
+	// Spectators who can see everything (protected by lobby.clientsMu; see
+	// peek.go), and what they were last sent, by player name ("" for the deck)
+	peeking map[*Client]bool
+	peeked  map[string]string
 }
 
 func newGame(lobby *Lobby) *Game {
 	return &Game{
+		id:            atomic.AddUint64(&lastGameID, 1),
 		lobby:         lobby,
 		spectators:    make(map[*Client]bool),
 		hands:         make(map[*Client]*Hand),
+		held:          make(map[*Client]map[int]bool),
+		ready:         make(map[*Client]bool),
+		sittingOut:    make(map[*Client]bool),
+		peeking:       make(map[*Client]bool),
+		peeked:        make(map[string]string),
 		currentPlayer: -1,
+		actions:       newRingBuffer(conf().Game.ActionHistory),
 	}
 }
 
@@ -93,7 +135,12 @@ func (g *Game) playerByName(name string) (player *Client) {
 
 func (g *Game) upgradePlayer(client *Client) {
 	// Move a player from the spectators into the players
+	g.lobby.clientsMu.Lock()
+	g.lobby.flushDelayed(client)
+	g.lobby.clientsMu.Unlock()
+
 	delete(g.spectators, client)
+	delete(g.sittingOut, client)
 	g.players = append(g.players, client)
 
 	g.lobby.sendBcast("upgrades " + client.name)
@@ -101,6 +148,9 @@ func (g *Game) upgradePlayer(client *Client) {
 
 	// Display a message to tell the client they are playing
 	client.sendMsg("message playing")
+
+	// They won't be ready yet
+	g.checkReady()
 }
 
 func (g *Game) downgradePlayer(client *Client) {
@@ -111,20 +161,35 @@ func (g *Game) downgradePlayer(client *Client) {
 
 	clientToRemove := g.playerNumber(client)
 	g.players = append(g.players[:clientToRemove], g.players[clientToRemove+1:]...)
+	if clientToRemove < g.currentPlayer {
+		// Keep pointing at the same player
+		g.currentPlayer--
+	}
 	g.spectators[client] = true
 	delete(g.hands, client)
+	delete(g.ready, client)
+	if g.started {
+		g.knockedOut = append(g.knockedOut, client.name)
+	}
 
 	g.lobby.sendBcast("downgrades " + client.name)
 	g.lobby.sendBcast("players" + g.playerList())
 
+	// One less vote to count
+	g.countVotes()
+
 	if !g.started {
 		// Display a message to tell the client they are spectating
 		client.sendMsg("message spectating")
+		g.checkReady()
 		return
 	}
 
 	// Gracefully remove the player from the game in progress
 	client.sendMsg("message spectating_exploded")
+	if g.lobby.peekingAllowed() {
+		client.sendMsg("notice can_peek")
+	}
 
 	// Erase their hand
 	client.sendMsg("hand")
@@ -151,16 +216,22 @@ func (g *Game) downgradePlayer(client *Client) {
 	// (so we can deal with it in answersQuestion)
 
 	// If they are currently playing, advance to the next player
+	// (any turns they owed die with them)
 	if currentlyPlaying && len(g.players) > 0 {
+		g.turnsOwed = 1
 		g.nextTurn()
 	}
 }
 
 func (g *Game) wins(winner *Client) {
 	g.lobby.sendBcast("wins " + winner.name)
+	g.lobby.recordGame(g, winner)
+	if t := g.lobby.getTournament(); t != nil {
+		t.tableFinished(g.lobby, g.placings(winner))
+	}
 
 	// This function runs a separate goroutine, so it's safe to sleep
-	time.Sleep(5 * time.Second)
+	time.Sleep(conf().Game.NewGameDelay.Duration)
 
 	// Destroy the game and create a new one
 	g.lobby.sendBcast("hand")
@@ -169,33 +240,130 @@ func (g *Game) wins(winner *Client) {
 	g.lobby.sendBcast("bcast new_game")
 
 	g.lobby.clientsMu.Lock()
-	defer g.lobby.clientsMu.Unlock()
-
-	g.lobby.currentGame = newGame(g.lobby)
+	next := newGame(g.lobby)
+	g.lobby.setGame(next)
 	for client := range g.lobby.clients {
-		g.lobby.currentGame.addPlayer(client)
+		next.addPlayer(client)
 		// We add a very short delay to allow each joining client to be processed separately
 		time.Sleep(50 * time.Millisecond)
 	}
+	keepSeats := g.lobby.match.keepSeats
+	g.lobby.clientsMu.Unlock()
+
+	if keepSeats {
+		// Same players again, as long as they're still here
+		seats := []string{}
+		for _, player := range g.players {
+			seats = append(seats, player.name)
+		}
+		next.reseat(append(seats, g.knockedOut...))
+	}
+	next.seatQueue()
+	next.autoFill()
 
 	// The GC should now be able to collect this old game object, I think
 }
 
-func (g *Game) netburst(client *Client) {
-	// Communicates the current game state to a newly joining client
-	client.sendMsg("spectators" + g.spectatorList())
-	client.sendMsg("players" + g.playerList())
+func (g *Game) netburst(client listener) {
+	// Communicates the current game state to a newly joining client,
+	// so that they see what everyone else sees (later, if they're
+	// spectating and there's a delay)
+	// /!\ needs a lock on g.lobby.clients
+	send := g.lobby.sender(client)
+
+	send("spectators" + g.spectatorList())
+	send("players" + g.playerList())
+	g.sendVote(send)
 
 	// Display a message to tell the client they are spectating
 	if !g.started {
-		client.sendMsg("message spectating")
+		send("message spectating")
+		if g.countdown != nil {
+			send("countdown " + strconv.Itoa(int(time.Until(g.countdownEnds).Seconds())))
+		}
 		return
 	}
 
 	// allow the client to spectate a game-in-progress
-	client.sendMsg("message spectating_started")
+	send("message spectating_started")
+
+	// What's happened recently
+	for _, msg := range g.actions.all() {
+		send("history " + msg)
+	}
+
+	if len(g.knockedOut) > 0 {
+		send("knocked_out " + strings.Join(g.knockedOut, " "))
+	}
+
+	if g.currentPlayer >= 0 && g.currentPlayer < len(g.players) {
+		send("now_playing " + g.players[g.currentPlayer].name)
+		send("turns_left " + strconv.Itoa(g.turnsOwed))
+	}
+
+	send("cards_left " + strconv.Itoa(g.deck.cardsLeft()))
 	if g.deck.cardsLeft() > 0 {
-		client.sendMsg("draw_pile yes")
+		send("draw_pile yes")
+	} else {
+		send("draw_pile no")
+	}
+
+	if g.discard != "" {
+		send("discard " + g.discard)
+	}
+
+	// Anything we're waiting on
+	if g.defusing {
+		send("pending defuse " + g.players[g.currentPlayer].name)
+	}
+	if g.favouring != nil {
+		pending := "pending " + [...]string{"", "favour", "random", "steal"}[g.favourType] +
+			" " + g.favouring.name
+		if g.favoured != nil {
+			pending += " " + g.favoured.name
+		}
+		send(pending)
+	}
+}
+
+func (g *Game) resync(client *Client) {
+	// Sends a client everything they need to carry on, public and private,
+	// after they've lost track
+	// /!\ needs a lock on g.lobby.clients
+	g.netburst(client)
+
+	if g.playerNumber(client) == -1 {
+		return
+	}
+
+	if !g.started {
+		client.sendMsg("message playing")
+		return
+	}
+
+	client.sendMsg("clear_message")
+	client.sendMsg("hand" + g.hands[client].cardList())
+
+	// Anything they were in the middle of
+	if g.defusing && g.players[g.currentPlayer] == client {
+		if g.defusePlayed {
+			client.sendMsg("q defuse_pos")
+		} else {
+			client.sendMsg("defusing")
+		}
+	}
+	if g.favoured == client && g.favourType == 1 {
+		client.sendMsg("q favour_what " + g.favouring.name)
+	}
+	if g.favouring == client {
+		switch {
+		case g.favoured == nil:
+			client.sendMsg("q " + [...]string{"", "favour_who", "random_who", "steal_who"}[g.favourType])
+		case g.favourType == 1:
+			client.sendMsg("lock")
+		case g.favourType == 3:
+			client.sendMsg("q steal_what")
+		}
 	}
 }
 
@@ -207,21 +375,76 @@ func (g *Game) spectatorList() (list string) {
 }
 
 func (g *Game) playerList() (list string) {
+	// Everyone's public state, as name:cards:state:turns
+	// Players who have exploded stay on the end of the list until the next game
 	for _, player := range g.players {
-		list = list + " " + player.name
+		list = list + " " + g.playerState(player)
+	}
+	for _, name := range g.knockedOut {
+		list = list + " " + name + ":0:exploded:0"
 	}
 	return
 }
 
+func (g *Game) playerState(player *Client) string {
+	// What everyone can see about a player
+	cards := 0
+	if hand, ok := g.hands[player]; ok {
+		cards = hand.getLength()
+	}
+
+	state := "alive"
+	if !g.started && g.ready[player] {
+		state = "ready"
+	}
+	owed := 0
+	if g.started && g.currentPlayer < len(g.players) && g.players[g.currentPlayer] == player {
+		owed = g.turnsOwed
+		if g.defusing {
+			state = "defusing"
+		}
+	}
+
+	return player.name + ":" + strconv.Itoa(cards) + ":" + state + ":" + strconv.Itoa(owed)
+}
+
+func (g *Game) sendHand(player *Client) {
+	// Sends a player their hand, and tells everyone else how big it is now
+	g.noteHeld(player)
+	player.sendMsg("hand" + g.hands[player].cardList())
+	g.lobby.sendBcast("player_state " + g.playerState(player))
+}
+
+func (g *Game) noteHeld(player *Client) {
+	// Remembers which cards a player has seen in their hand
+	if g.held[player] == nil {
+		g.held[player] = make(map[int]bool)
+	}
+	for _, card := range g.hands[player].cards {
+		g.held[player][card.id] = true
+	}
+}
+
 func (g *Game) readFromClient(c *Client, msg string) {
 	fields := strings.Fields(msg)
 
+	if g.paused && pausedMoves[fields[0]] {
+		c.sendMsg("notice paused")
+		return
+	}
+
+	// Whatever happens, anyone peeking should see it
+	defer g.updatePeekers()
+
 	switch fields[0] {
-	case "join":
+	case "join", "queue":
 		// Joining the game (from spectators)
 
 		if g.started {
-			// You can't join an active game
+			// You can't join an active game, but you can wait for the next one
+			if g.spectators[c] && g.lobby.table == nil {
+				g.lobby.enqueue(c)
+			}
 			break
 		}
 
@@ -231,6 +454,17 @@ func (g *Game) readFromClient(c *Client, msg string) {
 			break
 		}
 
+		if g.lobby.isBanned(c.name) {
+			c.sendMsg("notice banned")
+			break
+		}
+
+		if g.lobby.table != nil {
+			// The tournament decides who plays here
+			c.sendMsg("notice tournament_table")
+			break
+		}
+
 		g.upgradePlayer(c)
 
 	case "leave":
@@ -242,29 +476,37 @@ func (g *Game) readFromClient(c *Client, msg string) {
 			break
 		}
 
+		// Don't sit them straight back down again
+		g.sittingOut[c] = true
 		g.downgradePlayer(c)
+		g.autoFill()
 
-	case "start":
+	case "unqueue":
+		g.lobby.dequeue(c)
+
+	case "ready", "start":
+		// "start" is what people are used to typing
 		if g.started {
 			break
 		}
 
-		if len(g.players) < 2 {
+		limits := conf().Game
+
+		// Nobody is ready for a game that can't start
+		if len(g.players) < limits.MinPlayers {
 			c.sendMsg("bcast min_players")
 			break
 		}
 
-		if len(g.players) > 6 {
-			g.lobby.sendBcast("bcast max_players")
+		if len(g.players) > limits.MaxPlayers {
+			c.sendMsg("bcast max_players")
 			break
 		}
 
-		if len(g.players) == 6 {
-			// Warning message
-			g.lobby.sendBcast("bcast high_players")
-		}
+		g.setReady(c, true)
 
-		g.start()
+	case "unready":
+		g.setReady(c, false)
 
 	case "draw":
 		_, ok := g.spectators[c]
@@ -273,7 +515,7 @@ func (g *Game) readFromClient(c *Client, msg string) {
 		}
 
 		if g.currentPlayer >= len(g.players) {
-			log.Println("Player out of range")
+			c.log().Warn("Player out of range")
 			break
 		}
 
@@ -288,7 +530,7 @@ func (g *Game) readFromClient(c *Client, msg string) {
 		}
 
 		if g.defusing {
-			log.Println("Defusing")
+			c.log().Debug("Can't draw while defusing")
 			break
 		}
 
@@ -305,7 +547,7 @@ func (g *Game) readFromClient(c *Client, msg string) {
 		}
 
 		if g.currentPlayer >= len(g.players) {
-			log.Println("Player out of range")
+			c.log().Warn("Player out of range")
 			break
 		}
 
@@ -315,25 +557,27 @@ func (g *Game) readFromClient(c *Client, msg string) {
 			break
 		}
 
-		card, err := strconv.Atoi(fields[1])
-		if err != nil {
-			c.sendMsg("err illegal_move")
+		card, ok := g.findCard(c, fields[1])
+		if !ok {
 			break
 		}
 
-		if card >= g.hands[c].getLength() {
-			c.sendMsg("err illegal_move")
+		cardText := g.hands[c].getCard(card).name
+
+		cardType := cardTypes.get(cardText)
+
+		if g.defusing && cardType.Effect != effectDefuse {
+			c.log().Debug("Can only play a defuse while defusing")
 			break
 		}
 
-		cardText := g.hands[c].getCard(card)
-
-		if g.defusing && cardText != "defuse" {
-			log.Println("Defusing")
+		if cardType.Effect != effectNope && g.players[g.currentPlayer].name != c.name {
+			c.sendMsg("err illegal_move")
 			break
 		}
 
-		if cardText != "nope" && g.players[g.currentPlayer].name != c.name {
+		if cardType.Cat {
+			// Cat cards only work in pairs and threes
 			c.sendMsg("err illegal_move")
 			break
 		}
@@ -341,7 +585,7 @@ func (g *Game) readFromClient(c *Client, msg string) {
 		g.favouring = nil
 		g.favoured = nil
 		g.hands[c].removeCard(card)
-		c.sendMsg("hand" + g.hands[c].cardList())
+		g.sendHand(c)
 		g.playsCard(c, cardText)
 
 	case "play_multiple":
@@ -350,7 +594,9 @@ func (g *Game) readFromClient(c *Client, msg string) {
 			break
 		}
 
-		if len(fields) != 3 {
+		// The IDs of two or three cards of the same type
+		if len(fields) != 3 && len(fields) != 4 {
+			c.sendMsg("err illegal_move")
 			break
 		}
 
@@ -367,24 +613,35 @@ func (g *Game) readFromClient(c *Client, msg string) {
 			break
 		}
 
-		num, err := strconv.Atoi(fields[1])
-		if err != nil || num > 3 || num < 2 {
-			c.sendMsg("err illegal_move")
-			break
+		ids := make(map[int]bool)
+		cardText := ""
+		valid := true
+		for _, field := range fields[1:] {
+			card, ok := g.findCard(c, field)
+			if !ok {
+				valid = false
+				break
+			}
+			this := g.hands[c].getCard(card)
+			if ids[this.id] || (cardText != "" && this.name != cardText) {
+				c.sendMsg("err illegal_move")
+				valid = false
+				break
+			}
+			ids[this.id] = true
+			cardText = this.name
 		}
-
-		if !g.hands[c].containsMultiple(fields[2], num) {
-			c.sendMsg("err illegal_move")
+		if !valid {
 			break
 		}
 
 		g.favouring = nil
 		g.favoured = nil
-		for i := 0; i < num; i++ {
-			g.hands[c].removeByName(fields[2])
+		for id := range ids {
+			g.hands[c].removeCard(g.hands[c].find(id))
 		}
-		c.sendMsg("hand" + g.hands[c].cardList())
-		g.playsCombo(c, fields[2], num)
+		g.sendHand(c)
+		g.playsCombo(c, cardText, len(ids))
 
 	case "a":
 		if len(fields) != 3 {
@@ -396,6 +653,21 @@ func (g *Game) readFromClient(c *Client, msg string) {
 
 		g.answersQuestion(c, fields[1], fields[2])
 
+	case "pause", "unpause":
+		g.callVote(c, fields[0], "")
+
+	case "vote_kick":
+		if len(fields) != 2 {
+			break
+		}
+		g.callVote(c, "kick", fields[1])
+
+	case "vote":
+		if len(fields) != 2 || (fields[1] != "yes" && fields[1] != "no") {
+			break
+		}
+		g.castVote(c, fields[1] == "yes")
+
 	case "sort":
 		_, ok := g.spectators[c]
 		if ok {
@@ -408,10 +680,29 @@ func (g *Game) readFromClient(c *Client, msg string) {
 		c.sendMsg("hand" + g.hands[c].cardList())
 
 	default:
-		log.Println("Uncaught message from", c.name+":", msg)
+		c.log().Warn("Uncaught message", "line", redact(msg))
 	} // End switch
 }
 
+func (g *Game) findCard(c *Client, id string) (int, bool) {
+	// Looks up a card in a player's hand by its ID. If they don't have it,
+	// their hand must have changed under them, so let them try again
+	num, err := strconv.Atoi(id)
+	if err != nil {
+		c.sendMsg("err illegal_move")
+		return -1, false
+	}
+
+	card := g.hands[c].find(num)
+	if card == -1 {
+		c.sendMsg("stale_card " + id)
+		c.sendMsg("hand" + g.hands[c].cardList())
+		return -1, false
+	}
+
+	return card, true
+}
+
 func (g *Game) drawCard(c *Client) {
 	card := g.deck.draw()
 	g.history = nil
@@ -420,25 +711,27 @@ func (g *Game) drawCard(c *Client) {
 
 	g.lobby.sendBcast("cards_left "+strconv.Itoa(g.deck.cardsLeft()))
 
-	if card == "exploding" {
-		g.lobby.sendBcast("exploded " + c.name)
+	if cardTypes.effect(card.name) == effectExploding {
+		g.lobby.sendBcast("exploded " + c.name + " " + card.name)
 
-		if !g.hands[c].contains("defuse") {
+		if g.hands[c].findEffect(effectDefuse) == -1 {
 			g.downgradePlayer(c)
 			return
 		}
 
 		g.defusing = true
-		c.sendMsg("defusing")
+		g.defusingCard = card
+		g.defusePlayed = false
+		g.private(c, "defusing")
 
 		g.nextTurn()
 		return
 	}
 
 	g.hands[c].addCard(card)
-	c.sendMsg("hand" + g.hands[c].cardList())
+	g.sendHand(c)
 	// Tell the player what card they drew
-	c.sendMsg("drew " + card)
+	g.private(c, "drew "+card.name)
 	// Tell everyone else that a mystery card was drawn
 	g.lobby.sendComplexBcast("drew_other "+c.name, map[*Client]bool{c: true})
 
@@ -448,24 +741,29 @@ func (g *Game) drawCard(c *Client) {
 
 func (g *Game) playsCard(player *Client, card string) {
 	g.lobby.sendBcast("played " + player.name + " " + card)
+	g.discard = card
+
+	t := cardTypes.get(card)
 
 	// Every case here should do something with g.history
 	// - either make a backup, or clear it so that we can't
 	// NOPE too far back into history
-	switch card {
-	case "defuse":
+	switch t.Effect {
+	case effectDefuse:
 		if !g.defusing {
 			return
 		}
+		g.defusePlayed = true
 		player.sendMsg("q defuse_pos")
-	case "favour":
+	case effectFavour:
 		g.favouring = player
 		g.favourType = 1
+		g.history = nil
 		player.sendMsg("q favour_who")
-	case "shuffle":
-		g.history = makeGameState(g)
+	case effectShuffle:
+		g.saveHistory(t)
 		g.deck.shuffle()
-	case "nope":
+	case effectNope:
 		if g.history == nil {
 			g.lobby.sendBcast("bcast no_nope")
 			return
@@ -474,33 +772,48 @@ func (g *Game) playsCard(player *Client, card string) {
 		// - that way, you can NOPE a NOPE
 		history := makeGameState(g)
 		g.history.restore(g)
-		g.history = history
+		g.history = nil
+		if t.Nopeable {
+			g.history = history
+		}
 		g.nextTurn()
-	case "skip":
-		g.history = makeGameState(g)
+	case effectSkip:
+		g.saveHistory(t)
 		g.incrementTurn()
 		g.nextTurn()
-	case "attack":
-		g.history = makeGameState(g)
-		if g.attack {
-			// player is on the first turn of an attack
-			g.attack = false
-		} else {
-			g.currentPlayer++
-			g.attack = true
+	case effectAttack:
+		g.saveHistory(t)
+		// The next player takes two turns - or, if this player was attacked
+		// and still has turns to take, all of those plus two more
+		owed := 2
+		if g.turnsOwed > 1 {
+			owed = g.turnsOwed + 2
 		}
+		g.currentPlayer++
+		g.turnsOwed = owed
 		g.nextTurn()
-	case "see3":
+	case effectSee3:
 		cards := g.deck.peek(3)
-		player.sendMsg("seen " + strings.Join(cards, " "))
+		g.private(player, "seen "+strings.Join(cards, " "))
 		g.history = nil
 	default:
-		log.Println("unhandled card: ", card)
+		player.log().Error("Unhandled card", "card", card)
+	}
+}
+
+func (g *Game) saveHistory(t *CardType) {
+	// Back up the game state before a card takes effect,
+	// if the deck allows it to be NOPEd
+	if t.Nopeable {
+		g.history = makeGameState(g)
+	} else {
+		g.history = nil
 	}
 }
 
 func (g *Game) playsCombo(player *Client, card string, num int) {
 	g.lobby.sendBcast("played_multiple " + player.name + " " + strconv.Itoa(num) + " " + card)
+	g.discard = card
 
 	g.history = nil // TODO
 
@@ -541,7 +854,7 @@ func (g *Game) answersQuestion(player *Client, question string, answer string) {
 			break
 		}
 
-		g.deck.insertAtPos(pos, "exploding")
+		g.deck.insertAtPos(pos, g.defusingCard)
 		g.defusing = false
 		g.lobby.sendBcast("cards_left "+strconv.Itoa(g.deck.cardsLeft()))
 		g.incrementTurn()
@@ -586,12 +899,12 @@ func (g *Game) answersQuestion(player *Client, question string, answer string) {
 
 		g.lobby.sendComplexBcast("randomed "+player.name+" "+target.name,
 			map[*Client]bool{target: true, player: true})
-		target.sendMsg("random_gave "+player.name+" "+card)
-		player.sendMsg("random_recv "+target.name+" "+card)
+		g.private(target, "random_gave "+player.name+" "+card.name)
+		g.private(player, "random_recv "+target.name+" "+card.name)
 
 		g.hands[player].addCard(card)
-		player.sendMsg("hand" + g.hands[player].cardList())
-		target.sendMsg("hand" + g.hands[target].cardList())
+		g.sendHand(player)
+		g.sendHand(target)
 		g.favouring = nil
 		g.favoured = nil
 	case "steal_who":
@@ -613,14 +926,10 @@ func (g *Game) answersQuestion(player *Client, question string, answer string) {
 			break
 		}
 
-		card, err := strconv.Atoi(answer)
-		if err != nil {
-			player.sendMsg("err illegal_move")
-			break
-		}
-
-		if card >= g.hands[player].getLength() {
-			player.sendMsg("err illegal_move")
+		card, ok := g.findCard(player, answer)
+		if !ok {
+			// Ask again
+			player.sendMsg("q favour_what " + g.favouring.name)
 			break
 		}
 
@@ -630,15 +939,15 @@ func (g *Game) answersQuestion(player *Client, question string, answer string) {
 		// this is harder than it seems and will require some changes in the game's logic...
 
 		g.hands[player].removeCard(card)
-		player.sendMsg("hand" + g.hands[player].cardList())
+		g.sendHand(player)
 
 		g.hands[g.favouring].addCard(cardText)
-		g.favouring.sendMsg("hand" + g.hands[g.favouring].cardList())
+		g.sendHand(g.favouring)
 
 		// The favour transaction is complete
 		g.favouring.sendMsg("unlock")
-		g.favouring.sendMsg("favour_recv " + g.favoured.name + " " + cardText)
-		g.favoured.sendMsg("favour_gave " + g.favouring.name + " " + cardText)
+		g.private(g.favouring, "favour_recv "+g.favoured.name+" "+cardText.name)
+		g.private(g.favoured, "favour_gave "+g.favouring.name+" "+cardText.name)
 		g.lobby.sendComplexBcast("favour_complete "+g.favouring.name+" "+g.favoured.name,
 			map[*Client]bool{g.favoured: true, g.favouring: true})
 		g.favouring = nil
@@ -654,35 +963,55 @@ func (g *Game) answersQuestion(player *Client, question string, answer string) {
 			break
 		}
 
-		if !g.hands[g.favoured].contains(answer) {
-			g.lobby.sendBcast("steal_n "+g.favouring.name+" "+g.favoured.name+" "+answer)
+		// Either a type of card, or the ID of one they've had themselves
+		// (so they know what it is, and might know where it went)
+		wanted := answer
+		id, err := strconv.Atoi(answer)
+		if err == nil {
+			var known bool
+			wanted, known = g.deck.names[id]
+			if !known || !g.held[player][id] {
+				player.sendMsg("q steal_what")
+				break
+			}
+		}
+
+		if !g.hands[g.favoured].contains(wanted) {
+			g.lobby.sendBcast("steal_n "+g.favouring.name+" "+g.favoured.name+" "+wanted)
 		} else {
-			g.hands[g.favoured].removeByName(answer)
-			g.favoured.sendMsg("hand" + g.hands[g.favoured].cardList())
+			var card Card
+			if num := g.hands[g.favoured].find(id); err == nil && num != -1 {
+				card = g.hands[g.favoured].getCard(num)
+				g.hands[g.favoured].removeCard(num)
+			} else {
+				card = g.hands[g.favoured].removeByName(wanted)
+			}
+			g.sendHand(g.favoured)
 
-			g.hands[player].addCard(answer)
-			player.sendMsg("hand" + g.hands[g.favouring].cardList())
+			g.hands[player].addCard(card)
+			g.sendHand(player)
 
-			g.lobby.sendBcast("steal_y "+g.favouring.name+" "+g.favoured.name+" "+answer)
+			g.lobby.sendBcast("steal_y "+g.favouring.name+" "+g.favoured.name+" "+wanted)
 		}
 
 		g.favouring = nil
 		g.favoured = nil
 	default:
-		log.Println("unexpected Q/A: ", question, answer)
+		player.log().Warn("Unexpected Q/A", "question", question)
 	}
 }
 
 func (g *Game) incrementTurn() {
-	// Changes the turn counter to the next player
-	// (or not, if an attack has been played)
+	// Ends one turn, moving to the next player
+	// (or not, if the player still owes turns after an attack)
 
-	if g.attack {
-		g.attack = false
+	g.turnsOwed--
+	if g.turnsOwed > 0 {
 		return
 	}
 
 	g.currentPlayer++
+	g.turnsOwed = 1
 
 	return
 }
@@ -696,6 +1025,9 @@ func (g *Game) nextTurn() {
 	}
 
 	g.lobby.sendBcast("now_playing " + g.players[g.currentPlayer].name)
+	g.lobby.sendBcast("turns_left " + strconv.Itoa(g.turnsOwed))
+	// Whose turn it is, and who is defusing, are part of the players list
+	g.lobby.sendBcast("players" + g.playerList())
 	if g.deck.cardsLeft() == 0 {
 		g.lobby.sendBcast("draw_pile no")
 	} else {
@@ -707,6 +1039,8 @@ func (g *Game) start() {
 	// Starts the game
 
 	g.started = true
+	// In case a tournament got there first
+	g.stopCountdown()
 
 	g.lobby.sendBcast("clear_message")
 	g.lobby.sendBcast("bcast starting")
@@ -717,11 +1051,13 @@ func (g *Game) start() {
 		g.players[i], g.players[j] = g.players[j], g.players[i]
 	})
 	g.currentPlayer = 0
+	g.turnsOwed = 1
 	g.lobby.sendBcast("players" + g.playerList())
 	g.lobby.sendBcast("now_playing " + g.players[g.currentPlayer].name)
+	g.lobby.sendBcast("turns_left " + strconv.Itoa(g.turnsOwed))
 
 	// Generate the deck
-	g.deck = newDeck()
+	g.deck = newDeck(len(g.players))
 
 	// Give each player a hand
 	for _, player := range g.players {
@@ -732,10 +1068,18 @@ func (g *Game) start() {
 	// Shuffle in extra cards
 	g.deck.addExtraCards(len(g.players))
 
+	var hands []*Hand
+	for _, player := range g.players {
+		hands = append(hands, g.hands[player])
+	}
+	g.deck.number(hands)
+
 	// Sync the cards to the client
 	for _, player := range g.players {
+		g.noteHeld(player)
 		player.sendMsg("hand" + g.hands[player].cardList())
 	}
+	g.lobby.sendBcast("players" + g.playerList())
 	g.lobby.sendBcast("draw_pile yes")
 	g.lobby.sendBcast("cards_left "+strconv.Itoa(g.deck.cardsLeft()))
 }
@@ -1,5 +1,5 @@
 module github.com/albino/wwwcats
 
-go 1.14
+go 1.21
 
 require github.com/gorilla/websocket v1.4.2
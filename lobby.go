@@ -1,7 +1,8 @@
 package main
 
 import (
-	"log"
+	"log/slog"
+	"sync/atomic"
 	"strings"
 	"sync"
 
@@ -24,10 +25,61 @@ type Lobby struct {
 	clientsMu  sync.Mutex
 	register   chan *Client
 	unregister chan *Client
+	countdowns chan countdownEnd // (see ready.go)
+	voteEnds   chan voteEnd      // (see vote.go)
+	closing    chan bool         // Asks an empty lobby to close, e.g. a table nobody came to
 
 	currentGame *Game
+
+	// The ID of currentGame, which can be read from any goroutine
+	currentGameID uint64
+
+	// Recent chat, so people joining can catch up (protected by clientsMu)
+	chatHistory *ringBuffer
+
+	// Who can mute the chat, and whether they have (protected by clientsMu)
+	host      *Client
+	chatMuted bool
+
+	// Names voted out of this lobby (protected by clientsMu)
+	banned map[string]bool
+
+	// Scores over a series of games (protected by clientsMu; see match.go)
+	match *match
+
+	// Every lobby, so that tournaments can open tables (protected by
+	// lobbiesMu)
+	lobbies map[string]*Lobby
+
+	// The tournament this is the hall or a table for (protected by clientsMu;
+	// see tournament.go), and which table it is, if it's one (never changes)
+	tournament *Tournament
+	table      *table
+
+	// Whether spectators can see everyone's cards (protected by clientsMu;
+	// see peek.go)
+	allowPeeking bool
+
+	// Spectators waiting to play in the next game (protected by clientsMu;
+	// see queue.go)
+	queue []*Client
+
+	// Broadcasts held back from spectators (protected by clientsMu; see
+	// delay.go)
+	delayed map[listener]*feed
+
+	// Stream overlays following the game (protected by clientsMu; see
+	// overlay.go)
+	overlays map[*overlay]bool
+
+	// The address of whoever made the lobby, which counts against their limit
+	creator string
 }
 
+// The map of lobbies is shared between everyone joining them, the lobbies
+// themselves as they close, tournaments opening tables and overlays
+var lobbiesMu sync.Mutex
+
 func newLobby(name string) (lobby *Lobby) {
 	lobby = &Lobby{
 		name:    name,
@@ -38,11 +90,30 @@ func newLobby(name string) (lobby *Lobby) {
 
 		register:     make(chan *Client, 64),
 		unregister:   make(chan *Client, 64),
+		countdowns:   make(chan countdownEnd, 64),
+		voteEnds:     make(chan voteEnd, 64),
+		closing:      make(chan bool, 1),
+
+		chatHistory: newRingBuffer(conf().Lobby.ChatHistory),
+		match:       newMatch(),
+
+		allowPeeking: conf().Lobby.AllowPeeking,
+		delayed:      make(map[listener]*feed),
+		overlays:     make(map[*overlay]bool),
 	}
-	lobby.currentGame = newGame(lobby)
+	lobby.setGame(newGame(lobby))
 	return
 }
 
+func (l *Lobby) setGame(g *Game) {
+	l.currentGame = g
+	atomic.StoreUint64(&l.currentGameID, g.id)
+}
+
+func (l *Lobby) gameID() uint64 {
+	return atomic.LoadUint64(&l.currentGameID)
+}
+
 func (l *Lobby) run(lobbies map[string]*Lobby) {
 	// Goroutine to deal with all the tasks of the lobby
 
@@ -53,10 +124,12 @@ func (l *Lobby) run(lobbies map[string]*Lobby) {
 				l.destroyClient(client)
 			}
 
+			lobbiesMu.Lock()
 			delete(lobbies, l.name)
+			lobbiesMu.Unlock()
+			l.closed()
 
-			log.Printf("!!! PANIC in lobby %s: %v !!!", l.name, r)
-			debug.PrintStack()
+			slog.Error("PANIC in lobby", "lobby", l.name, "panic", r, "stack", string(debug.Stack()))
 		}
 	}()
 
@@ -64,13 +137,30 @@ func (l *Lobby) run(lobbies map[string]*Lobby) {
 		select {
 
 		case client := <-l.register:
-			l.clients[client] = true
-
 			// Sync the join to the game object
 			l.clientsMu.Lock()
+			l.clients[client] = true
 			l.currentGame.addPlayer(client)
+			// Catch up on the chat (only once - not with every new game)
+			for _, msg := range l.chatHistory.all() {
+				client.sendMsg("history " + msg)
+			}
+			if l.host == nil {
+				l.chooseHost()
+			} else {
+				client.sendMsg("host " + l.host.name)
+			}
+			l.sendMatch(client)
+			if len(l.queue) > 0 {
+				client.sendMsg(l.queueList())
+			}
 			l.clientsMu.Unlock()
 
+			if t := l.getTournament(); t != nil {
+				t.arrived(l, client)
+			}
+			l.currentGame.autoFill()
+
 		case client := <-l.unregister:
 			// Announce and sync
 			// We need to do this before we close the channel
@@ -79,46 +169,130 @@ func (l *Lobby) run(lobbies map[string]*Lobby) {
 			if _, ok := l.clients[client]; ok {
 				l.destroyClient(client)
 			}
+			l.dequeue(client)
 
 			if len(l.clients) == 0 {
 				// The lobby is finished
+				lobbiesMu.Lock()
 				delete(lobbies, l.name)
+				lobbiesMu.Unlock()
+				l.closed()
 				return
 			}
 
+			// Someone else can have their seat
+			l.currentGame.autoFill()
+
+			l.clientsMu.Lock()
+			l.chooseHost()
+			l.clientsMu.Unlock()
+
+		case end := <-l.countdowns:
+			end.game.countdownFinished(end.id)
+
+		case end := <-l.voteEnds:
+			end.game.voteExpired(end.id)
+
+		case <-l.closing:
+			if len(l.clients) > 0 || len(l.register) > 0 {
+				// Someone turned up after all
+				break
+			}
+			lobbiesMu.Lock()
+			delete(lobbies, l.name)
+			lobbiesMu.Unlock()
+			l.closed()
+			return
+
 		}
 	}
 }
 
+func (l *Lobby) closed() {
+	l.closeOverlays()
+	if l.creator != "" {
+		lobbyClosed(l.creator)
+	}
+	if t := l.getTournament(); t != nil {
+		t.hallClosed(l)
+	}
+}
+
 func (c *Client) joinToLobby(lobby_name string, player_name string, lobbies map[string]*Lobby) {
-	var lobby *Lobby
+	limits := conf().Lobby
+
+	lobbiesMu.Lock()
+	lobby := lobbies[lobby_name]
+	created := lobby == nil
+
+	if created {
+		if limits.MaxLobbies > 0 && len(lobbies) >= limits.MaxLobbies {
+			lobbiesMu.Unlock()
+			c.refuseJoin("too_many_lobbies")
+			return
+		}
+
+		if !canCreateLobby(c.addr) {
+			lobbiesMu.Unlock()
+			c.refuseJoin("too_many_own_lobbies")
+			return
+		}
+		if !c.allowedNewLobby() {
+			lobbiesMu.Unlock()
+			c.kick("flood")
+			return
+		}
 
-	if lobbies[lobby_name] == nil {
-		// Create the lobby, and start its goroutine
 		lobby = newLobby(lobby_name)
+		lobby.creator = c.addr
+		lobby.lobbies = lobbies
+		lobbyCreated(c.addr)
 		lobbies[lobby_name] = lobby
+	}
+	lobbiesMu.Unlock()
+
+	if created {
+		// Anyone else joining in the meantime waits in register until the
+		// goroutine starts, by which time it knows if it's a tournament's hall
+		if t := findTournament(lobby_name); t != nil {
+			t.reopen(lobby)
+		}
 		go lobby.run(lobbies)
-	} else {
-		lobby = lobbies[lobby_name]
+	}
+
+	if limits.MaxClients > 0 && lobby.clientCount() >= limits.MaxClients {
+		c.refuseJoin("lobby_full")
+		return
+	}
+
+	if lobby.isBanned(player_name) {
+		c.refuseJoin("banned")
+		return
 	}
 
 	// Avoid nickname collisions
-	for connected_client := range lobbies[lobby_name].clients {
-		if connected_client.name == player_name {
-			select {
-			case c.send <- []byte("err username_exists"):
-			default:
-				close(c.send)
-			}
-			// Nobody is getting joined to the lobby today
-			return
-		}
+	if lobby.clientByName(player_name) != nil {
+		c.refuseJoin("username_exists")
+		return
 	}
 
 	c.name = player_name
+	c.startSession()
 
-	lobby.register <- c
+	// Set this first, so the lobby's goroutine sees it
 	c.lobby = lobby
+	lobby.register <- c
+}
+
+func (c *Client) refuseJoin(reason string) {
+	c.sendMsg("err " + reason)
+	// Nobody is getting joined to the lobby today
+}
+
+func (l *Lobby) clientCount() int {
+	l.clientsMu.Lock()
+	defer l.clientsMu.Unlock()
+	return len(l.clients)
 }
 
 func (l *Lobby) readFromClient(c *Client, msg string) {
@@ -130,8 +304,22 @@ func (l *Lobby) readFromClient(c *Client, msg string) {
 
 	// Lobby-wide commands
 
-	if fields[0] == "chat" {
-		l.sendBcast("chat " + c.name + " " + msg[5:])
+	if l.readChat(c, fields, msg) {
+		return
+	}
+	if l.readMatch(c, fields) {
+		return
+	}
+	if l.readPeek(c, fields) {
+		return
+	}
+	if fields[0] == "tournament" {
+		l.readTournament(c, fields)
+		return
+	}
+	if fields[0] == "quit" {
+		// Going somewhere else, so there's no need to keep their place
+		c.quit()
 		return
 	}
 
@@ -147,8 +335,13 @@ func (l *Lobby) sendBcast(msg string) {
 }
 
 func (l *Lobby) sendBcastRaw(msg string) {
+	l.remember(msg)
+
 	for client := range l.clients {
-		client.sendMsg(msg)
+		l.sendDelayed(client, msg)
+	}
+	for o := range l.overlays {
+		l.sendDelayed(o, msg)
 	}
 }
 
@@ -156,22 +349,87 @@ func (l *Lobby) sendComplexBcast(text string, except map[*Client]bool) {
 	l.clientsMu.Lock()
 	defer l.clientsMu.Unlock()
 
+	l.remember(text)
+
 	for client := range l.clients {
 		_, ok := except[client]
 		if ok {
 			continue
 		}
 
-		client.sendMsg(text)
+		l.sendDelayed(client, text)
+	}
+	for o := range l.overlays {
+		l.sendDelayed(o, text)
+	}
+}
+
+// Public events worth replaying to people who join later
+var historyEvents = map[string]bool{
+	"now_playing":     true,
+	"played":          true,
+	"played_multiple": true,
+	"drew_other":      true,
+	"exploded":        true,
+	"favoured":        true,
+	"favour_complete": true,
+	"randomed":        true,
+	"random_n":        true,
+	"steal_y":         true,
+	"steal_n":         true,
+	"wins":            true,
+	"bcast":           true,
+}
+
+func (l *Lobby) remember(msg string) {
+	// Keeps a copy of broadcasts for netbursts
+	// /!\ needs a lock on l.clients
+	event := strings.SplitN(msg, " ", 2)[0]
+
+	if historyEvents[event] {
+		l.currentGame.actions.add(msg)
+	}
+}
+
+// A fixed number of the most recent lines, for catching people up
+type ringBuffer struct {
+	lines []string
+	next  int
+	size  int
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{size: size}
+}
+
+func (r *ringBuffer) add(line string) {
+	if r.size <= 0 {
+		return
+	}
+
+	if len(r.lines) < r.size {
+		r.lines = append(r.lines, line)
+	} else {
+		r.lines[r.next] = line
+	}
+	r.next = (r.next + 1) % r.size
+}
+
+func (r *ringBuffer) all() []string {
+	// Oldest first
+	if len(r.lines) < r.size {
+		return append([]string(nil), r.lines...)
 	}
+	return append(append([]string(nil), r.lines[r.next:]...), r.lines[:r.next]...)
 }
 
 func (l *Lobby) destroyClient(client *Client) {
 	l.clientsMu.Lock()
 	defer l.clientsMu.Unlock()
 
-	client.conn.Close()
+	l.dropDelayed(client)
+	client.closeSend()
+	client.endSession()
 	delete(l.clients, client)
-	close(client.send)
-	log.Printf("DELETING %s", client.name)
+	client.log().Debug("Deleting client")
 }
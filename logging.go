@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Logs are structured (key=value or JSON) and levelled. Every message to and
+// from a client is logged at debug level, with anything that would let you
+// cheat - hands, the top of the deck and so on - masked out. Lobbies listed in
+// log.trace_lobbies have every message logged in full, at info level.
+
+var logLevel = new(slog.LevelVar)
+
+func parseLevel(level string) (l slog.Level, err error) {
+	err = l.UnmarshalText([]byte(level))
+	return
+}
+
+func setupLogging(c *Config) error {
+	level, err := parseLevel(c.Log.Level)
+	if err != nil {
+		return err
+	}
+	logLevel.Set(level)
+
+	var w io.Writer = os.Stderr
+	if c.Log.File != "" {
+		w, err = newRotatingFile(c.Log.File, c.Log.MaxSize*1024*1024, c.Log.MaxFiles)
+		if err != nil {
+			return err
+		}
+	}
+
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler
+	if c.Log.Format == "json" {
+		handler = slog.NewJSONHandler(w, opts)
+	} else {
+		handler = slog.NewTextHandler(w, opts)
+	}
+
+	// This also catches anything still using the log package
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+func traced(lobby string) bool {
+	for _, name := range conf().Log.TraceLobbies {
+		if name == lobby {
+			return true
+		}
+	}
+	return false
+}
+
+// How many words of each message are safe to log; the rest are masked
+var hiddenMessages = map[string]int{
+	// Outgoing
+	"hand":        1,
+	"seen":        1,
+	"drew":        1,
+	"random_recv": 2,
+	"random_gave": 2,
+	"favour_recv": 2,
+	"favour_gave": 2,
+	"peek":        2,
+	"peek_deck":   1,
+
+	// Incoming
+	"a defuse_pos": 2,
+}
+
+func redact(msg string) string {
+	fields := strings.Fields(msg)
+	if len(fields) == 0 {
+		return msg
+	}
+
+	keep, ok := hiddenMessages[fields[0]]
+	if !ok && len(fields) > 1 {
+		keep, ok = hiddenMessages[fields[0]+" "+fields[1]]
+	}
+	if !ok || len(fields) <= keep {
+		return msg
+	}
+
+	for i := keep; i < len(fields); i++ {
+		fields[i] = "*"
+	}
+	return strings.Join(fields, " ")
+}
+
+func (c *Client) log() *slog.Logger {
+	// A logger which tags everything with who we're talking about
+	logger := slog.With("client", c.id)
+	if c.name != "" {
+		logger = logger.With("name", c.name)
+	}
+	if c.lobby != nil {
+		logger = logger.With("lobby", c.lobby.name, "game", c.lobby.gameID())
+	}
+	return logger
+}
+
+func (c *Client) logMessage(direction string, msg string) {
+	if c.lobby != nil && traced(c.lobby.name) {
+		c.log().Info(direction, "line", msg)
+		return
+	}
+
+	if !slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		// Don't bother redacting something nobody will see
+		return
+	}
+	c.log().Debug(direction, "line", redact(msg))
+}
+
+// A log file which is moved out of the way once it gets too big,
+// keeping a few old ones around as file.1, file.2 etc.
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	file *os.File
+	size int64
+	mu   sync.Mutex
+}
+
+func newRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+func (r *rotatingFile) rotate() error {
+	r.file.Close()
+
+	// Shuffle the old files along, losing the oldest
+	for i := r.maxFiles - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if r.maxFiles > 0 {
+		os.Rename(r.path, r.path+".1")
+	} else {
+		os.Remove(r.path)
+	}
+
+	return r.open()
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxSize > 0 && r.size+int64(len(p)) > r.maxSize && r.size > 0 {
+		if err := r.rotate(); err != nil {
+			// Nowhere else to complain to
+			log.New(os.Stderr, "", log.LstdFlags).Println("!!! Couldn't rotate log file:", err)
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
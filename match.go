@@ -0,0 +1,202 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Scores carried over from game to game in a lobby. Each game gives points
+// by placement (game.points: the winner, then whoever exploded last, and
+// so on), and after a series of games whoever has the most points wins the
+// match. The host can change things between games:
+//
+//	match <games>     play a best-of-<games> series (0 for no end)
+//	rematch on|off    put the same players back in their seats each game
+//	scores            (anyone) see the scoreboard again
+//
+// Everyone is sent "match <games> <played> on|off" when this changes,
+// "scores name:points ..." after each game, best first, and
+// "match_winner <points> <name> [<name>...]" at the end of a series.
+
+type match struct {
+	length    int // Games in the series; 0 means no end
+	played    int
+	keepSeats bool
+	scores    map[string]int
+}
+
+func newMatch() *match {
+	return &match{
+		length:    conf().Game.SeriesLength,
+		keepSeats: conf().Game.KeepSeats,
+		scores:    make(map[string]int),
+	}
+}
+
+func (l *Lobby) readMatch(c *Client, fields []string) bool {
+	// Returns true if the message was a match command
+	switch fields[0] {
+	case "match", "rematch":
+		l.clientsMu.Lock()
+		defer l.clientsMu.Unlock()
+
+		if c != l.host {
+			c.sendMsg("notice not_host")
+			break
+		}
+		if len(fields) != 2 {
+			c.sendMsg("notice usage_" + fields[0])
+			break
+		}
+		if l.currentGame.started {
+			c.sendMsg("notice match_started")
+			break
+		}
+
+		if fields[0] == "match" {
+			length, err := strconv.Atoi(fields[1])
+			if err != nil || length < 0 {
+				c.sendMsg("notice usage_match")
+				break
+			}
+
+			// A new series starts from nothing
+			keepSeats := l.match.keepSeats
+			l.match = newMatch()
+			l.match.length = length
+			l.match.keepSeats = keepSeats
+		} else {
+			if fields[1] != "on" && fields[1] != "off" {
+				c.sendMsg("notice usage_rematch")
+				break
+			}
+			l.match.keepSeats = fields[1] == "on"
+		}
+
+		l.sendBcastRaw(l.match.settings())
+		l.sendBcastRaw(l.match.scoreboard())
+
+	case "scores":
+		l.clientsMu.Lock()
+		c.sendMsg(l.match.scoreboard())
+		l.clientsMu.Unlock()
+
+	default:
+		return false
+	}
+
+	return true
+}
+
+func (g *Game) placings(winner *Client) []string {
+	// Winner first, then whoever exploded last, and so on
+	placings := []string{winner.name}
+	for i := len(g.knockedOut) - 1; i >= 0; i-- {
+		placings = append(placings, g.knockedOut[i])
+	}
+	return placings
+}
+
+func (l *Lobby) recordGame(g *Game, winner *Client) {
+	// Gives out points at the end of a game, and finishes the series if
+	// that was the last game
+	l.clientsMu.Lock()
+	defer l.clientsMu.Unlock()
+
+	m := l.match
+	points := conf().Game.Points
+
+	for i, name := range g.placings(winner) {
+		// Everyone who played goes on the scoreboard, even with nothing
+		earned := 0
+		if i < len(points) {
+			earned = points[i]
+		}
+		m.scores[name] += earned
+	}
+	m.played++
+
+	l.sendBcastRaw(m.settings())
+	l.sendBcastRaw(m.scoreboard())
+
+	if m.length == 0 || m.played < m.length {
+		return
+	}
+
+	// That's the series; on to the next one with the same settings
+	best := -1
+	var winners []string
+	for _, name := range m.ranking() {
+		if m.scores[name] < best {
+			break
+		}
+		best = m.scores[name]
+		winners = append(winners, name)
+	}
+	l.sendBcastRaw("match_winner " + strconv.Itoa(best) + " " + strings.Join(winners, " "))
+
+	next := newMatch()
+	next.length = m.length
+	next.keepSeats = m.keepSeats
+	l.match = next
+}
+
+func (l *Lobby) sendMatch(client *Client) {
+	// Catches a joining client up on the series
+	// /!\ needs a lock on l.clients
+	client.sendMsg(l.match.settings())
+	if l.match.played > 0 {
+		client.sendMsg(l.match.scoreboard())
+	}
+}
+
+func (m *match) settings() string {
+	seats := "off"
+	if m.keepSeats {
+		seats = "on"
+	}
+	return "match " + strconv.Itoa(m.length) + " " + strconv.Itoa(m.played) + " " + seats
+}
+
+func (m *match) ranking() []string {
+	names := make([]string, 0, len(m.scores))
+	for name := range m.scores {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if m.scores[names[i]] != m.scores[names[j]] {
+			return m.scores[names[i]] > m.scores[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+func (m *match) scoreboard() string {
+	msg := "scores"
+	for _, name := range m.ranking() {
+		msg += " " + name + ":" + strconv.Itoa(m.scores[name])
+	}
+	return msg
+}
+
+func (g *Game) reseat(names []string) {
+	// Puts the players from the last game back in, if they're still here
+	for _, name := range names {
+		var client *Client
+
+		g.lobby.clientsMu.Lock()
+		for spec := range g.spectators {
+			if spec.name == name {
+				client = spec
+			}
+		}
+		banned := g.lobby.banned[name]
+		g.lobby.clientsMu.Unlock()
+
+		if client != nil && !banned {
+			g.upgradePlayer(client)
+		}
+	}
+}
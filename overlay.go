@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A read-only feed of a lobby's game, for stream overlays, at
+// /overlay?lobby=<name>. It's a stream of Server-Sent Events, each a JSON
+// object with an "event" saying what happened, e.g.
+//
+//	{"event":"now_playing","player":"alice"}
+//	{"event":"players","players":[{"name":"alice","cards":8,"state":"alive","turns":1}]}
+//	{"event":"played","player":"bob","card":"attack"}
+//
+// It sees what spectators see, when they see it (see delay.go), starting
+// with the state of the game when it connects. The lobby has to exist.
+
+type overlay struct {
+	events chan string
+	closed bool // (protected by the lobby's clientsMu)
+}
+
+// Fields of the public events we pass on, by event
+var overlayEvents = map[string][]string{
+	"now_playing":     {"player"},
+	"turns_left":      {"turns"},
+	"cards_left":      {"cards"},
+	"discard":         {"card"},
+	"no_discard":      {},
+	"drew_other":      {"player"},
+	"played":          {"player", "card"},
+	"played_multiple": {"player", "count", "card"},
+	"exploded":        {"player", "card"},
+	"favoured":        {"player", "target"},
+	"favour_complete": {"player", "target"},
+	"randomed":        {"player", "target"},
+	"random_n":        {"player", "target"},
+	"steal_y":         {"player", "target", "card"},
+	"steal_n":         {"player", "target", "card"},
+	"wins":            {"player"},
+	"paused":          {},
+	"unpaused":        {},
+}
+
+// Announcements worth passing on, which come as "bcast <what>"
+var overlayBcasts = map[string]bool{
+	"starting": true,
+	"new_game": true,
+}
+
+func (o *overlay) sendMsg(msg string) {
+	// /!\ needs a lock on the lobby's clients
+	if o.closed {
+		return
+	}
+
+	event := overlayEvent(msg)
+	if event == nil {
+		return
+	}
+	data, _ := json.Marshal(event)
+
+	select {
+	case o.events <- "data: " + string(data) + "\n\n":
+	default:
+		// Not keeping up; they'll have to reconnect
+		o.closed = true
+		close(o.events)
+	}
+}
+
+func overlayEvent(msg string) map[string]any {
+	// The JSON for a protocol message, or nil if it's not for overlays
+	fields := strings.Fields(msg)
+	if len(fields) == 0 {
+		return nil
+	}
+	event := map[string]any{"event": fields[0]}
+
+	switch fields[0] {
+	case "players", "knocked_out":
+		players := []any{}
+		for _, state := range fields[1:] {
+			if fields[0] == "players" {
+				players = append(players, overlayPlayer(state))
+			} else {
+				players = append(players, state)
+			}
+		}
+		event["players"] = players
+
+	case "player_state":
+		if len(fields) != 2 {
+			return nil
+		}
+		event["player"] = overlayPlayer(fields[1])
+
+	case "bcast":
+		if len(fields) != 2 || !overlayBcasts[fields[1]] {
+			return nil
+		}
+		event["event"] = fields[1]
+
+	default:
+		names, ok := overlayEvents[fields[0]]
+		if !ok || len(fields)-1 < len(names) {
+			return nil
+		}
+		for i, name := range names {
+			event[name] = overlayValue(fields[i+1])
+		}
+	}
+
+	return event
+}
+
+func overlayPlayer(state string) map[string]any {
+	// From name:cards:state:turns, as in Game.playerList
+	parts := strings.Split(state, ":")
+	player := map[string]any{"name": parts[0]}
+	for i, name := range []string{"cards", "state", "turns"} {
+		if i+1 < len(parts) {
+			player[name] = overlayValue(parts[i+1])
+		}
+	}
+	return player
+}
+
+func overlayValue(field string) any {
+	// Numbers as numbers, and everything else as it comes
+	if n, err := strconv.Atoi(field); err == nil {
+		return n
+	}
+	return field
+}
+
+func (l *Lobby) closeOverlays() {
+	// The lobby has finished, so there's nothing more to follow
+	l.clientsMu.Lock()
+	defer l.clientsMu.Unlock()
+
+	for o := range l.overlays {
+		if !o.closed {
+			o.closed = true
+			close(o.events)
+		}
+		l.dropDelayed(o)
+		delete(l.overlays, o)
+	}
+}
+
+func serveOverlay(w http.ResponseWriter, r *http.Request, lobbies map[string]*Lobby) {
+	lobbiesMu.Lock()
+	l := lobbies[r.URL.Query().Get("lobby")]
+	lobbiesMu.Unlock()
+	if l == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming isn't supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Each stream counts as a connection, like a player's
+	addr := clientAddr(r)
+	if !connectFrom(addr) {
+		http.Error(w, "Too many connections", http.StatusTooManyRequests)
+		return
+	}
+	defer disconnectFrom(addr)
+
+	o := &overlay{events: make(chan string, conf().Websocket.SendBuffer)}
+
+	l.clientsMu.Lock()
+	l.overlays[o] = true
+	l.currentGame.netburst(o)
+	l.clientsMu.Unlock()
+
+	defer func() {
+		l.clientsMu.Lock()
+		delete(l.overlays, o)
+		l.dropDelayed(o)
+		l.clientsMu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Overlays are often local pages, and there's nothing secret here
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// Keep quiet connections from being dropped along the way
+	keepalive := time.NewTicker(conf().Websocket.PongWait.Duration)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case event, ok := <-o.events:
+			if !ok {
+				return
+			}
+			fmt.Fprint(w, event)
+
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
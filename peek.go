@@ -0,0 +1,146 @@
+package main
+
+import (
+	"strings"
+)
+
+// Spectators watching with everything showing. If the lobby allows it
+// (lobby.allow_peeking, or "peeking on|off" from the host), anyone spectating
+// a game in progress, including players who have exploded, can send "peek".
+// They're then sent "peeking", followed by a copy of everything private as it
+// happens, as "peek <player> <message>" (including "peek <player> hand ..."),
+// and the top of the deck as "peek_deck <card> ...". There's no going back:
+// until the next game, they can only chat to other spectators.
+
+func (l *Lobby) readPeek(c *Client, fields []string) bool {
+	// Returns true if the message was about peeking
+	switch fields[0] {
+	case "peeking":
+		l.clientsMu.Lock()
+		defer l.clientsMu.Unlock()
+
+		if c != l.host {
+			c.sendMsg("notice not_host")
+			break
+		}
+		if len(fields) != 2 || (fields[1] != "on" && fields[1] != "off") {
+			c.sendMsg("notice usage_peeking")
+			break
+		}
+		if l.table != nil {
+			// Tournaments are played straight
+			c.sendMsg("notice tournament_table")
+			break
+		}
+
+		l.allowPeeking = fields[1] == "on"
+		l.sendBcastRaw("notice peeking_" + fields[1] + " " + c.name)
+
+	case "peek":
+		l.currentGame.peek(c)
+
+	default:
+		return false
+	}
+
+	return true
+}
+
+func (g *Game) peek(c *Client) {
+	l := g.lobby
+
+	l.clientsMu.Lock()
+	defer l.clientsMu.Unlock()
+
+	switch {
+	case !l.allowPeeking:
+		c.sendMsg("notice no_peeking")
+		return
+	case !g.started || !g.spectators[c]:
+		c.sendMsg("notice cant_peek")
+		return
+	case g.peeking[c]:
+		return
+	}
+
+	g.peeking[c] = true
+	c.sendMsg("peeking")
+
+	// Everything so far
+	for _, player := range g.players {
+		l.sendDelayed(c, "peek "+player.name+" hand"+g.hands[player].cardList())
+	}
+	l.sendDelayed(c, g.deckTop())
+}
+
+func (l *Lobby) peekingAllowed() bool {
+	l.clientsMu.Lock()
+	defer l.clientsMu.Unlock()
+	return l.allowPeeking
+}
+
+func (l *Lobby) isPeeking(c *Client) bool {
+	l.clientsMu.Lock()
+	defer l.clientsMu.Unlock()
+	return l.currentGame.peeking[c]
+}
+
+func (g *Game) private(player *Client, msg string) {
+	// Sends a player something only they should know, and a copy to anyone
+	// peeking
+	player.sendMsg(msg)
+	g.sendPeekers("peek " + player.name + " " + msg)
+}
+
+func (g *Game) sendPeekers(msg string) {
+	l := g.lobby
+
+	l.clientsMu.Lock()
+	defer l.clientsMu.Unlock()
+
+	if !l.allowPeeking {
+		return
+	}
+	for c := range g.peeking {
+		l.sendDelayed(c, msg)
+	}
+}
+
+func (g *Game) updatePeekers() {
+	// Sends anyone peeking whichever hands, and the top of the deck, have
+	// changed since last time
+	l := g.lobby
+
+	l.clientsMu.Lock()
+	defer l.clientsMu.Unlock()
+
+	if !g.started || !l.allowPeeking || len(g.peeking) == 0 {
+		return
+	}
+
+	var changes []string
+	for _, player := range g.players {
+		hand := "hand" + g.hands[player].cardList()
+		if g.peeked[player.name] != hand {
+			g.peeked[player.name] = hand
+			changes = append(changes, "peek "+player.name+" "+hand)
+		}
+	}
+
+	top := g.deckTop()
+	if g.peeked[""] != top {
+		g.peeked[""] = top
+		changes = append(changes, top)
+	}
+
+	for c := range g.peeking {
+		for _, msg := range changes {
+			l.sendDelayed(c, msg)
+		}
+	}
+}
+
+func (g *Game) deckTop() string {
+	// The next few cards, top first
+	return strings.TrimSpace("peek_deck " + strings.Join(g.deck.peek(3), " "))
+}
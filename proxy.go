@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Working out who is really connecting when we're behind a reverse proxy,
+// and which websites are allowed to connect to us
+
+// Proxies whose X-Forwarded-* headers we believe; filled in from the settings
+var trustedProxies []*net.IPNet
+
+func parseNetworks(list []string) (networks []*net.IPNet, err error) {
+	// Accepts both single addresses and CIDR ranges
+	for _, item := range list {
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an IP address", item)
+			}
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return
+}
+
+func isTrusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func clientAddr(r *http.Request) string {
+	// The address of whoever is on the other end, looking through any
+	// trusted proxies in the way
+	addr := remoteHost(r)
+	if !isTrusted(addr) {
+		return addr
+	}
+
+	// Each proxy adds the address it got the request from on the end,
+	// so work backwards until we reach one we don't trust
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr = strings.TrimSpace(hops[i])
+		if !isTrusted(addr) {
+			break
+		}
+	}
+
+	return addr
+}
+
+func requestScheme(r *http.Request) string {
+	if isTrusted(remoteHost(r)) {
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+			return strings.ToLower(strings.TrimSpace(strings.Split(proto, ",")[0]))
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+func requestHost(r *http.Request) string {
+	// The host the browser asked for, which the proxy may have changed
+	if isTrusted(remoteHost(r)) {
+		if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+			return strings.TrimSpace(strings.Split(host, ",")[0])
+		}
+	}
+	return r.Host
+}
+
+func checkOrigin(allowed []string) func(r *http.Request) bool {
+	// Without a list, only pages served by us can connect,
+	// which is what the websocket library does by default
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			// Not a browser
+			return true
+		}
+
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+
+		if len(allowed) == 0 {
+			return strings.EqualFold(u.Host, requestHost(r))
+		}
+
+		for _, a := range allowed {
+			if a == "*" || strings.EqualFold(strings.TrimSuffix(a, "/"), u.Scheme+"://"+u.Host) {
+				return true
+			}
+		}
+
+		slog.Warn("Refused websocket", "addr", clientAddr(r), "origin", origin)
+		return false
+	}
+}
@@ -1,12 +1,24 @@
 var GameState = function() {
-	// Websocket handle
+	// Websocket handle (or an HTTPConn, if websockets don't get through)
 
 	this.conn = null;
+	this.connected = false; // Whether we've ever got through
+	this.plainHTTP = false;
+
+	// Every message from the server is numbered, so that nothing gets lost
+	// or out of order, even if we have to reconnect
+	this.session = null;
+	this.nextSeq = 1;
+	this.lastAcked = 0;
+	this.pending = {}; // Messages that arrived early, by number
+	this.resuming = false;
+	this.reconnects = 0;
 
 	this.started = false;
 	this.defusing = false;
 	this.ourTurn = false;
 	this.locked = false;
+	this.paused = false;
 	this.favouring = false;
 	this.combo = 1;
 
@@ -14,9 +26,25 @@ var GameState = function() {
 
 	this.name = "";
 	this.lobby = "";
+	this.host = ""; // Who can mute the chat
+	this.match = {length: 0, played: 0, keepSeats: true};
+	this.bracket = null; // The tournament this lobby is part of, if any
+	this.queue = []; // Spectators waiting for the next game
+
+	// Everyone's cards, if we're peeking
+	this.peeking = false;
+	this.peekHands = {};
+	this.peekDeck = [];
+	this.leaving = false; // Going to another lobby
 
 	this.nowPlaying = "";
-	this.players = [];
+	this.turnsLeft = 1;
+	this.players = []; // Names of players still in the game
+	this.playerStates = []; // Everyone's public state, in turn order
+
+	// Set while catching up on things that happened before we joined,
+	// so that we don't play animations for them
+	this.replaying = false;
 
 	// Assets
 
@@ -138,6 +166,24 @@ var GameState = function() {
 		this.started = true;
 	}
 
+	this.cardHUD = function(card, time) {
+		if (!this.replaying) {
+			cardHUD(card, time);
+		}
+	}
+
+	this.cardHUD3 = function(cards, time) {
+		if (!this.replaying) {
+			cardHUD3(cards, time);
+		}
+	}
+
+	this.animate = function(el, prop, start, end, step, unit) {
+		if (!this.replaying) {
+			animate(el, prop, start, end, step, unit);
+		}
+	}
+
 	this.console = function(msg) {
 		$("#game-log").append(msg+"<br />");
 
@@ -145,25 +191,130 @@ var GameState = function() {
 		$("#game-log").scrollTop($("#game-log")[0].scrollHeight);
 	}
 
+	this.parsePlayerState = function(entry) {
+		// name:cards:state:turns - the name might have colons in it
+		let fields = entry.split(":");
+		let n = fields.length;
+		return {
+			name: entities(fields.slice(0, n-3).join(":")),
+			cards: parseInt(fields[n-3]),
+			state: fields[n-2],
+			turns: parseInt(fields[n-1])
+		};
+	}
+
 	this.drawPlayerList = function() {
 		$("#player-list").empty();
-		this.players.forEach(x => $("#player-list").append("<li>"+x+"</li>"));
 
 		(function(gameState) {
-			$("#player-list > li").each( function() {
-				if ($( this ).html() == gameState.nowPlaying) {
-					$( this ).append("<span id='now-playing-mark' style='color:red'> *</span>");
+			gameState.playerStates.forEach(function(p) {
+				let li = $("<li></li>");
+				if (p.state == "exploded") {
+					li.html("<s style='color:#888'>"+p.name+"</s>");
+				} else {
+					li.html(p.name);
 				}
-			} );
+				if (gameState.nowPlaying != "" && p.state != "exploded") {
+					li.append("<span class='hand-size' style='color:#ccc'> ("+p.cards+")</span>");
+				}
+				if (p.state == "defusing") {
+					li.append("<span style='color:purple'> !</span>");
+				}
+				if (p.state == "ready") {
+					li.append("<span style='color:lightgreen'> &#10003;</span>");
+				}
+				if (p.name == gameState.nowPlaying) {
+					let mark = p.turns > 1 ? " *" + p.turns : " *";
+					li.append("<span id='now-playing-mark' style='color:red'>" + mark + "</span>");
+				}
+				if (gameState.peeking && gameState.peekHands[p.name] && p.state != "exploded") {
+					let cards = gameState.peekHands[p.name].map(card => strings["card_"+card]);
+					li.append("<br /><small style='color:#888'>"+cards.join(", ")+"</small>");
+				}
+				$("#player-list").append(li);
+			});
+
+			if (gameState.peeking && gameState.peekDeck.length > 0) {
+				let cards = gameState.peekDeck.map(card => strings["card_"+card]);
+				$("#player-list").append("<li><small style='color:#888'>Deck: "+cards.join(", ")+"</small></li>");
+			}
 		})(this);
 	}
 
+	this.stopPeeking = function() {
+		this.peeking = false;
+		this.peekHands = {};
+		this.peekDeck = [];
+	}
+
+	this.receive = function(ev) {
+		// Puts messages from the server in order before handling them
+		let space = ev.data.indexOf(" ");
+		let seq = parseInt(ev.data.substring(0, space));
+		let msg = ev.data.substring(space+1);
+
+		if (this.resuming) {
+			// Until the server finds our old session, messages are about the new connection
+			if (msg.startsWith("resumed ")) {
+				this.nextSeq = parseInt(msg.substring(8));
+				this.pending = {};
+				this.resuming = false;
+				this.reconnects = 0;
+				this.console(strings["reconnected"]);
+			} else {
+				this.readFromServer({data: msg});
+			}
+			return;
+		}
+
+		if (msg == "resync") {
+			// Everything from here on starts afresh
+			this.pending = {};
+			this.nextSeq = seq;
+		}
+
+		if (seq < this.nextSeq) {
+			// We've had this one already
+			return;
+		}
+		if (seq > this.nextSeq) {
+			// We've missed something, so hold on to this until the gap is filled
+			if (Object.keys(this.pending).length == 0) {
+				this.send("resend "+this.nextSeq+" "+(seq-1));
+			}
+			this.pending[seq] = msg;
+			return;
+		}
+
+		this.readFromServer({data: msg});
+		this.nextSeq++;
+		while (this.nextSeq in this.pending) {
+			let next = this.pending[this.nextSeq];
+			delete this.pending[this.nextSeq];
+			this.readFromServer({data: next});
+			this.nextSeq++;
+		}
+
+		// Let the server know it can forget what we've seen
+		if (this.nextSeq - 1 - this.lastAcked >= 20) {
+			this.lastAcked = this.nextSeq - 1;
+			this.send("ack "+this.lastAcked);
+		}
+	}
+
+	this.resume = function() {
+		// Picks up where we left off on a new connection
+		this.resuming = true;
+		this.conn.send("resume "+this.session+" "+(this.nextSeq-1));
+	}
+
 	this.readFromServer = function(ev) {
 		var parts = ev.data.split(" ");
 
 		// TODO: cleanup and refactor
 
 		if (parts[0] == "err") {
+			this.session = null; // Don't try to reconnect
 			alert(strings[parts[1]]);
 			this.conn.close();
 			this.conn = null;
@@ -176,6 +327,34 @@ var GameState = function() {
 			return;
 		}
 
+		if (parts[0] == "history") {
+			// Something that happened before we joined
+			this.replaying = true;
+			this.readFromServer({data: ev.data.substring(8)});
+			this.replaying = false;
+			return;
+		}
+
+		if (parts[0] == "session") {
+			this.session = parts[1];
+			return;
+		}
+
+		if (parts[0] == "resync") {
+			// The server is about to send us everything again
+			this.console(strings["resync"]);
+			$("#card-deck").empty();
+			$("#discard-pile").html("");
+			this.defusing = false;
+			this.favouring = false;
+			this.locked = false;
+			this.paused = false;
+			this.stopPeeking();
+			this.ourTurn = false;
+			this.nowPlaying = "";
+			return;
+		}
+
 		if (parts[0] == "joins" && parts[1] == this.name) {
 			// We're in!
 			if (!this.started) {
@@ -198,7 +377,8 @@ var GameState = function() {
 
 		if (parts[0] == "players") {
 			// Update players list
-			this.players = parts.slice(1).map(x => entities(x));
+			this.playerStates = parts.slice(1).map(x => this.parsePlayerState(x));
+			this.players = this.playerStates.filter(p => p.state != "exploded").map(p => p.name);
 			this.drawPlayerList();
 
 			return;
@@ -268,6 +448,51 @@ var GameState = function() {
 			return;
 		}
 
+		if (parts[0] == "chat_me") {
+			let encodedName = entities(parts[1]);
+			let encodedMsg = entities(ev.data.substring(parts[1].length + 9));
+			this.console("<i>* "+encodedName+" "+encodedMsg+"</i>");
+
+			return;
+		}
+
+		if (parts[0] == "whisper") {
+			// We're either the sender or the recipient
+			let from = entities(parts[1]);
+			let to = entities(parts[2]);
+			let encodedMsg = entities(ev.data.substring(parts[1].length + parts[2].length + 10));
+			this.console("<span style='color:violet'>"+from+" &rarr; "+to+": "+encodedMsg+"</span>");
+
+			return;
+		}
+
+		if (parts[0] == "chat_team") {
+			let encodedName = entities(parts[2]);
+			let encodedMsg = entities(ev.data.substring(parts[1].length + parts[2].length + 12));
+			this.console("<span style='color:lightblue'>["+strings["channel_"+parts[1]]+"] "
+				+encodedName+": "+encodedMsg+"</span>");
+
+			return;
+		}
+
+		if (parts[0] == "notice") {
+			// Answers to chat commands, sometimes naming someone
+			let msg = strings["notice_"+parts[1]];
+			if (parts.length > 2) {
+				msg = msg.replace("%s", entities(parts[2]));
+			}
+			this.console("<span style='color:grey'>"+msg+"</span>");
+
+			return;
+		}
+
+		if (parts[0] == "host") {
+			this.host = parts[1];
+			this.console("<span style='color:grey'>"+entities(parts[1])+" is the host of this lobby.</span>");
+
+			return;
+		}
+
 		if (parts[0] == "message") {
 			// This refers to the 'message' container in the middle of the board
 			// that can be used to display useful game info
@@ -289,16 +514,51 @@ var GameState = function() {
 			let msg = strings["bcast_"+ev.data.substring(6)];
 			this.console(msg);
 
+			if (parts[1] == "new_game") {
+				// Back to seeing only what everyone else sees
+				this.stopPeeking();
+			}
+			return;
+		}
+
+		if (parts[0] == "peeking") {
+			this.peeking = true;
+			this.console("<span style='color:#888'>"+strings["peeking"]+"</span>");
+			return;
+		}
+
+		if (parts[0] == "peek") {
+			// Something private to another player
+			let who = entities(parts[1]);
+			if (parts[2] == "hand") {
+				this.peekHands[who] = parts.slice(3).map(card => card.substring(card.indexOf(":")+1));
+				this.drawPlayerList();
+			} else {
+				let words = parts.slice(3).map(w => strings["card_"+w] || entities(w));
+				this.console("<span style='color:#888'>["+who+"] "+entities(parts[2])+" "+words.join(" ")+"</span>");
+			}
+			return;
+		}
+
+		if (parts[0] == "peek_deck") {
+			this.peekDeck = parts.slice(1);
+			this.drawPlayerList();
 			return;
 		}
 
 		if (parts[0] == "hand") {
 			$("#card-deck").empty();
 
-			for (var i=1; i < parts.length; i++) {
-				var card = $("<img class='card' src='assets/card_"+parts[i]+".png' />");
+			// Each card comes as id:name
+			let hand = parts.slice(1).map(function(x) {
+				let pair = x.split(":");
+				return {id: pair[0], name: pair[1]};
+			});
 
-				(function (gameState, cardNo, cardName) {
+			for (var i=0; i < hand.length; i++) {
+				var card = $("<img class='card' src='assets/card_"+hand[i].name+".png' />");
+
+				(function (gameState, cardID, cardName) {
 					card.on("click", function() {
 						if (gameState.locked) {
 							return;
@@ -306,44 +566,37 @@ var GameState = function() {
 
 						if (gameState.favouring) {
 							// Favour NOPE-logic is handled server-side :)
-							gameState.send("a favour_what "+cardNo.toString());
+							gameState.send("a favour_what "+cardID);
 							gameState.favouring = false;
 							return;
 						}
 
 						if (gameState.combo > 1) {
-							// Do we have enough cards?
-							let cards = 0;
-							for (var j=1; j < parts.length; j++) {
-								if (parts[j] == cardName) {
-									cards++;
-									if (cards == gameState.combo) {
-										break;
-									}
-								}
-							}
-							if (cards < gameState.combo) {
+							// Do we have enough cards? (starting with the one clicked)
+							let ids = [cardID].concat(hand.filter(x => x.name == cardName && x.id != cardID)
+								.map(x => x.id)).slice(0, gameState.combo);
+							if (ids.length < gameState.combo) {
 								gameState.console("You don't have enough " + strings["card_"+cardName] +
 									" cards to do that!");
 								return;
 							}
 
 							if (gameState.ourTurn) {
-								gameState.send("play_multiple "+gameState.combo.toString()+" "+
-									cardName);
+								gameState.send("play_multiple "+ids.join(" "));
 								gameState.resetButtons();
 								return;
 							}
 						}
 
-						if ((gameState.ourTurn || cardName === "nope") && !cardName.startsWith("random")
-								&& ( (!gameState.defusing && cardName !== "defuse")
-								||    (gameState.defusing && cardName === "defuse") )) {
-							gameState.send("play "+cardNo.toString());
+						let effect = cardTypes[cardName].effect;
+						if ((gameState.ourTurn || effect === "nope") && !cardTypes[cardName].cat
+								&& ( (!gameState.defusing && effect !== "defuse")
+								||    (gameState.defusing && effect === "defuse") )) {
+							gameState.send("play "+cardID);
 							gameState.defusing = false;
 						}
 					});
-				})(this, i-1, parts[i]);
+				})(this, hand[i].id, hand[i].name);
 
 				$("#card-deck").append(card);
 			}
@@ -375,7 +628,9 @@ var GameState = function() {
 			// cmp with raw data because name isn't stored encoded
 			if (parts[1] == this.name) {
 				this.ourTurn = true;
-				this.assets["atomic.ogg"].play();
+				if (!this.replaying) {
+					this.assets["atomic.ogg"].play();
+				}
 				document.title = strings["title_alert"];
 			} else {
 				this.ourTurn = false;
@@ -385,11 +640,22 @@ var GameState = function() {
 			return;
 		}
 
+		if (parts[0] == "turns_left") {
+			this.turnsLeft = parseInt(parts[1]);
+			if (this.turnsLeft > 1) {
+				this.console("<span style='color:yellow'>"+this.nowPlaying+" has "+this.turnsLeft+
+					" turns to take.</span>");
+			}
+			this.drawPlayerList();
+
+			return;
+		}
+
 		if (parts[0] == "drew") {
 			this.console("You drew <span style='color:orange'>"+strings["card_"+parts[1]]+".</span>");
 
 			// Animation
-			cardHUD(parts[1], 2000);
+			this.cardHUD(parts[1], 2000);
 
 			return;
 		}
@@ -399,7 +665,7 @@ var GameState = function() {
 
 			// Animation
 			$("#draw-pile-animation").html("<img src='assets/card_back.png' class='card' />");
-			animate("#draw-pile-animation", "right", 125, -150, -15, "px");
+			this.animate("#draw-pile-animation", "right", 125, -150, -15, "px");
 
 			return;
 		}
@@ -408,7 +674,7 @@ var GameState = function() {
 			let encoded = entities(parts[1]);
 			this.console("<span style='color:purple'>"+encoded+" drew a Detonating Cat!</span>");
 
-			cardHUD("exploding", 1000);
+			this.cardHUD(parts[2], 1000);
 
 			return;
 		}
@@ -433,8 +699,8 @@ var GameState = function() {
 			
 			$("#discard-pile").html("<img class='card' src='assets/card_"+parts[2]+".png' />");
 
-			if (parts[2] != "see3") {
-				cardHUD(parts[2], 1000);
+			if (cardTypes[parts[2]].effect != "see3") {
+				this.cardHUD(parts[2], 1000);
 			}
 
 			return;
@@ -447,14 +713,46 @@ var GameState = function() {
 			$("#discard-pile").html("<img class='card' src='assets/card_"+parts[3]+".png' />");
 
 			if (parts[2] == 2) {
-				cardHUD3([parts[3], parts[3]], 1000);
+				this.cardHUD3([parts[3], parts[3]], 1000);
 			} else {
-				cardHUD3([parts[3], parts[3], parts[3]], 1000);
+				this.cardHUD3([parts[3], parts[3], parts[3]], 1000);
 			}
 
 			return;
 		}
 
+		if (parts[0] == "discard") {
+			$("#discard-pile").html("<img class='card' src='assets/card_"+parts[1]+".png' />");
+			return;
+		}
+
+		if (parts[0] == "knocked_out") {
+			let names = parts.slice(1).map(x => entities(x));
+			this.console("<span style='color:purple'>Out of this round: "+names.join(", ")+".</span>");
+			return;
+		}
+
+		if (parts[0] == "player_state") {
+			let updated = this.parsePlayerState(parts[1]);
+			this.playerStates = this.playerStates.map(p => p.name == updated.name ? updated : p);
+			this.drawPlayerList();
+			return;
+		}
+
+		if (parts[0] == "pending") {
+			let who = entities(parts[2]);
+			let msg;
+			if (parts[1] == "defuse") {
+				msg = who+" is defusing a Detonating Cat.";
+			} else if (parts.length > 3) {
+				msg = who+" is waiting on "+entities(parts[3])+" for a "+parts[1]+".";
+			} else {
+				msg = who+" is choosing who to ask for a "+parts[1]+".";
+			}
+			this.console("<span style='color:yellow'>"+msg+"</span>");
+			return;
+		}
+
 		if (parts[0] == "no_discard") {
 			$("#discard-pile").html("");
 			return;
@@ -490,7 +788,7 @@ var GameState = function() {
 		}
 
 		if (parts[0] == "seen") {
-			cardHUD3(parts.slice(1), 2000);
+			this.cardHUD3(parts.slice(1), 2000);
 			this.console("You saw "+strings["card_"+parts[1]]+", "+strings["card_"+parts[2]]+" and "+
 				strings["card_"+parts[3]]+".");
 			return;
@@ -511,7 +809,7 @@ var GameState = function() {
 			let card = strings["card_"+parts[2]];
 			if (parts[0] == "favour_recv") {
 				this.console(remotePlayer + " gave you <span style='color:orange'>" + card + "</span>.");
-				cardHUD(parts[2], 2000);
+				this.cardHUD(parts[2], 2000);
 			} else {
 				this.console("You gave " + remotePlayer + " <span style='color:orange'>" + card + "</span>.");
 			}
@@ -540,7 +838,7 @@ var GameState = function() {
 				this.console(remotePlayer+" randomly took <span style='color:orange'>"+card+
 					"</span> from you.");
 			}
-			cardHUD(parts[2], 2000);
+			this.cardHUD(parts[2], 2000);
 			return;
 		}
 
@@ -550,7 +848,7 @@ var GameState = function() {
 			if (parts[0] == "steal_y") {
 				this.console(perpetrator+" stole <span style='color:orange'>"+
 					strings["card_"+parts[3]]+"</span> from "+victim+"!");
-				cardHUD(parts[3], 2000);
+				this.cardHUD(parts[3], 2000);
 			} else {
 				this.console(perpetrator+" asked "+victim+" for <span style='color:orange'>"
 					+strings["card_"+parts[3]]+"</span>, but ended up empty-handed!");
@@ -558,6 +856,138 @@ var GameState = function() {
 			return;
 		}
 
+		if (parts[0] == "stale_card") {
+			// Our hand changed before the server got our click; a new one is on its way
+			this.console(strings["stale_card"]);
+			return;
+		}
+
+		if (parts[0] == "vote_started") {
+			let by = entities(parts[2]);
+			let what = strings["vote_"+parts[1]];
+			if (parts.length > 3) {
+				what = what.replace("%s", entities(parts[3]));
+			}
+			this.console("<span style='color:yellow'>"+by+" wants to "+what+". Type <b>/vote yes</b> or <b>/vote no</b>.</span>");
+			return;
+		}
+
+		if (parts[0] == "vote_count") {
+			this.console("<span style='color:grey'>Votes: "+parts[1]+" yes, "+parts[2]+" no ("+parts[3]+" needed)</span>");
+			return;
+		}
+
+		if (parts[0] == "vote_passed" || parts[0] == "vote_failed" || parts[0] == "vote_expired") {
+			let what = strings["vote_"+parts[1]];
+			if (parts.length > 2) {
+				what = what.replace("%s", entities(parts[2]));
+			}
+			this.console("<span style='color:yellow'>The vote to "+what+
+				{vote_passed: " passed.", vote_failed: " failed.", vote_expired: " ran out of time."}[parts[0]]+"</span>");
+			return;
+		}
+
+		if (parts[0] == "paused") {
+			this.paused = true;
+			$("#message").html(strings["message_paused"]);
+			$("#message-container").removeClass("reveal");
+			return;
+		}
+
+		if (parts[0] == "unpaused") {
+			this.paused = false;
+			$("#message").html("");
+			$("#message-container").addClass("reveal");
+			return;
+		}
+
+		if (parts[0] == "match") {
+			this.match = {length: parseInt(parts[1]), played: parseInt(parts[2]), keepSeats: parts[3] == "on"};
+			return;
+		}
+
+		if (parts[0] == "scores") {
+			if (parts.length < 2) {
+				return;
+			}
+			let board = parts.slice(1).map(function(entry) {
+				// Names can have colons in them, but points can't
+				let split = entry.lastIndexOf(":");
+				return entities(entry.substring(0, split))+" "+entry.substring(split+1);
+			});
+			let title = "Scores";
+			if (this.match.length > 0) {
+				title += " after game "+this.match.played+" of "+this.match.length;
+			}
+			this.console("<span style='color:yellow'>"+title+": "+board.join(", ")+"</span>");
+			return;
+		}
+
+		if (parts[0] == "match_winner") {
+			let names = parts.slice(2).map(entities).join(" and ");
+			this.console("<span style='color:yellow'><b>"+names+" won the match with "+parts[1]+" points!</b></span>");
+			return;
+		}
+
+		if (parts[0] == "queue") {
+			// Who's waiting for the next game
+			let queued = parts.slice(1);
+			if (queued.includes(this.name) && !this.queue.includes(this.name)) {
+				this.console("<span style='color:yellow'>"+strings["queued"]+"</span>");
+			}
+			this.queue = queued;
+			if (queued.length > 0) {
+				this.console("<span style='color:grey'>Waiting for the next game: "+queued.map(entities).join(", ")+"</span>");
+			}
+			return;
+		}
+
+		if (parts[0] == "countdown") {
+			this.console("<span style='color:yellow'>"+strings["countdown"].replace("%s", parts[1])+"</span>");
+			return;
+		}
+
+		if (parts[0] == "countdown_cancelled") {
+			this.console("<span style='color:yellow'>"+strings["countdown_cancelled"]+"</span>");
+			return;
+		}
+
+		if (parts[0] == "bracket") {
+			let bracket = JSON.parse(ev.data.substring(8));
+			let rounds = bracket.rounds || [];
+			let last = this.bracket ? (this.bracket.rounds || []).length : -1;
+			this.bracket = bracket;
+
+			if (rounds.length > last && rounds.length > 0) {
+				// A new round has been drawn up
+				let round = rounds[rounds.length-1];
+				let tables = round.tables.map(function(table) {
+					return "<b>"+entities(table.lobby)+"</b>: "+table.seats.map(entities).join(", ");
+				});
+				if (round.byes) {
+					tables.push("byes: "+round.byes.map(entities).join(", "));
+				}
+				this.console("<span style='color:yellow'>Round "+rounds.length+" - "+tables.join("; ")+"</span>");
+			} else if (last == -1 && bracket.state == "registering") {
+				this.console("<span style='color:yellow'>"+strings["tournament_registering"]+"</span>");
+			}
+			return;
+		}
+
+		if (parts[0] == "tournament_table") {
+			// Off to our table, in another lobby
+			this.console("<span style='color:yellow'>"+strings["tournament_table"]+"</span>");
+			this.leaving = true;
+			this.send("quit");
+			location.search = "?lobby="+encodeURIComponent(parts[1])+"&name="+encodeURIComponent(this.name)+"&join";
+			return;
+		}
+
+		if (parts[0] == "tournament_winner") {
+			this.console("<span style='color:yellow'><b>"+entities(parts[1])+" has won the tournament!</b></span>");
+			return;
+		}
+
 		if (parts[0] == "lock") {
 			this.locked = true;
 			return;
@@ -571,6 +1001,8 @@ var GameState = function() {
 	}
 
 	this.send = function(msg) {
-		this.conn.send(msg);
+		if (this.conn && this.conn.readyState === WebSocket.OPEN) {
+			this.conn.send(msg);
+		}
 	}
 }
@@ -1,4 +1,5 @@
-var REVISION = "9";
+// The server writes this into the page
+var REVISION = document.getElementById("REVISION").value;
 
 (function () {
 
@@ -10,16 +11,6 @@ var REVISION = "9";
 	$( document ).ready(function () {
 		// Set up the game
 
-		// Check that the JS and HTML have matching versions
-		// (caching is weird)
-		var htmlRev = $("#REVISION").val();
-		if (htmlRev != REVISION) {
-			setTimeout(function() {
-				location.reload();
-			}, 1500);
-			return;
-		}
-
 		// Create the changelog iframe
 		// We use this roundabout method to make sure the changelog is always
 		// re-downloaded every time REVISION changes
@@ -28,25 +19,22 @@ var REVISION = "9";
 		changelog.id = "changelog";
 		document.getElementById("changelog-container").appendChild(changelog);
 		
-		// Load assets
-		var assets = [
-			"wood.jpg",
-			"card_back.png",
-			"card_defuse.png",
-			"card_exploding.png",
-			"card_nope.png",
-			"card_skip.png",
-			"card_attack.png",
-			"card_see3.png",
-			"card_favour.png",
-			"card_shuffle.png",
-			"card_random1.png",
-			"card_random2.png",
-			"card_random3.png",
-			"card_random4.png",
-			"card_random5.png",
-			"atomic.ogg"
-		];
+		// Find out which cards the server is using, then load their assets
+		fetch("cards.json").then(resp => resp.json()).then(loadAssets);
+	});
+
+	function loadAssets(defs) {
+		// Card names come from the server, so that the deck can be changed
+		cards = [];
+		defs.forEach(function(def) {
+			cards.push(def.id);
+			cardTypes[def.id] = def;
+			strings["card_"+def.id] = def.name;
+		});
+
+		var assets = ["wood.jpg", "card_back.png", "atomic.ogg"];
+		cards.forEach(card => assets.push("card_"+card+".png"));
+
 		var promises = [];
 		var assetsLoaded = 0;
 
@@ -80,7 +68,7 @@ var REVISION = "9";
 		// Once all the promises have resolved (all assets loaded), call the function to 
 		// display the welcome page.
 		$.when.apply($, promises).done(welcomePage);
-	});
+	}
 
 	function welcomePage() {
 		// Transition loading screen -> welcome page
@@ -89,6 +77,14 @@ var REVISION = "9";
 
 		$("#loading").toggleClass("reveal");
 		$("#welcome").toggleClass("reveal");
+
+		// Links (and tournaments) can fill in the form for us
+		let params = new URLSearchParams(location.search);
+		$("#welcome-lobby").val(params.get("lobby") || "");
+		$("#welcome-username").val(params.get("name") || "");
+		if (params.has("join") && params.get("lobby") && params.get("name")) {
+			joinGame();
+		}
 	}
 
 	function joinGame() {
@@ -111,13 +107,51 @@ var REVISION = "9";
 			return;
 		}
 
-		gameState.conn = new WebSocket("ws://" + location.host + "/ws");
+		connect();
+	}
+
+	function connect() {
+		let scheme = location.protocol == "https:" ? "wss://" : "ws://";
+		// The server might not be at the root of the site
+		let path = location.pathname.replace(/[^\/]*$/, "");
+		if (gameState.plainHTTP) {
+			gameState.conn = new HTTPConn(path + "sse");
+		} else {
+			gameState.conn = new WebSocket(scheme + location.host + path + "ws");
+		}
 
 		gameState.conn.onopen = function () {
-			gameState.conn.send("join_lobby " + gameState.lobby + " " + gameState.name);
+			gameState.connected = true;
+			if (gameState.session) {
+				gameState.resume();
+			} else {
+				gameState.conn.send("join_lobby " + gameState.lobby + " " + gameState.name);
+			}
 		}
 
 		gameState.conn.onclose = function () {
+			if (gameState.leaving) {
+				// On our way somewhere else
+				return;
+			}
+
+			if (!gameState.connected && !gameState.plainHTTP) {
+				// Websockets might be blocked, so try plain HTTP instead
+				gameState.plainHTTP = true;
+				connect();
+				return;
+			}
+
+			// The server keeps our place for a little while, so try to get it back
+			if (gameState.session && gameState.reconnects < 15) {
+				if (gameState.reconnects == 0) {
+					gameState.console(strings["reconnecting"]);
+				}
+				gameState.reconnects++;
+				setTimeout(connect, 2000);
+				return;
+			}
+
 			alert(strings["conn_closed"]);
 			location.reload();
 		};
@@ -126,7 +160,7 @@ var REVISION = "9";
 			// We wrap this in an anonymous function so that 'this'
 			// will refer to the GameState object and not the WebSocket
 			// (I don't have a clue how javascript OOP works)
-			gameState.readFromServer(ev);
+			gameState.receive(ev);
 		};
 	}
 })();
@@ -1,33 +1,76 @@
 var strings = {
-	"card_attack": "Attack",
-	"card_defuse": "Defuse",
-	"card_exploding": "Detonating Cat!",
-	"card_favour": "Favour",
-	"card_nope": "Nope!",
-	"card_random1": "BEANS Cat",
-	"card_random2": "CHUFFing Cat",
-	"card_random3": "Fridge Cat",
-	"card_random4": "Tacky Yack Cat",
-	"card_random5": "Bin Guitar Cat",
-	"card_see3": "See the Future (x3)",
-	"card_shuffle": "Shuffle",
-	"card_skip": "Skip",
 	"username_exists": "That username has already been taken. Please try and be more original.",
 	"already_connecting": "There is already an active connection. Please reload the page if this problem persists.",
 	"one_word": "Your name should be one word.",
 	"lobby_one_word": "Lobby names can only be one word.",
+	"lobby_full": "That lobby is full. Please try another one.",
+	"too_many_lobbies": "The server can't take any more lobbies at the moment. Please join an existing one, or try again later.",
+	"flood": "You've been disconnected for sending too much, too quickly.",
+	"too_many_connections": "There are too many connections from your address already.",
+	"too_many_own_lobbies": "You've made too many lobbies already. Please join an existing one, or try again later.",
+	"channel_players": "players",
+	"channel_spectators": "spectators",
+	"notice_too_long": "That message is too long.",
+	"notice_chat_muted": "The host has muted the chat.",
+	"notice_no_such_player": "There's nobody called %s here.",
+	"notice_muted": "You won't see any more chat from %s.",
+	"notice_unmuted": "You'll see chat from %s again.",
+	"notice_muteall": "%s has muted the chat.",
+	"notice_unmuteall": "%s has unmuted the chat.",
+	"notice_not_host": "Only the host can do that.",
+	"notice_wrong_channel": "Use <b>/p</b> to talk to the other players, or <b>/s</b> if you're spectating.",
+	"notice_usage_w": "To whisper, type <b>/w &lt;name&gt; &lt;message&gt;</b>.",
+	"notice_usage_mute": "To mute someone, type <b>/mute &lt;name&gt;</b>.",
+	"notice_usage_unmute": "To unmute someone, type <b>/unmute &lt;name&gt;</b>.",
+	"notice_help": "Chat commands: <b>/me</b> &lt;action&gt;, <b>/w</b> &lt;name&gt; &lt;message&gt;, <b>/p</b> or <b>/s</b> &lt;message&gt; for players or spectators only, <b>/mute</b> and <b>/unmute</b> &lt;name&gt;; the host can use <b>/muteall</b> and <b>/unmuteall</b>. To vote: <b>/pause</b>, <b>/unpause</b>, <b>/vote_kick</b> &lt;name&gt;. Matches: <b>/scores</b>; the host can use <b>/match</b> &lt;games&gt; and <b>/rematch</b> on|off. Tournaments: <b>/tournament</b>. Spectators: <b>/peek</b>; the host can use <b>/peeking</b> on|off.",
+	"notice_vote_running": "There's already a vote going on.",
+	"notice_cant_pause": "The game can't be paused right now.",
+	"notice_cant_unpause": "The game isn't paused.",
+	"notice_cant_kick": "You can only vote to kick another player, with at least three playing.",
+	"notice_paused": "The game is paused - type <b>/unpause</b> to vote to carry on.",
+	"notice_banned": "You've been voted out of this lobby.",
+	"vote_pause": "pause the game",
+	"vote_unpause": "carry on with the game",
+	"vote_kick": "kick %s",
+	"message_paused": "The game is paused.",
+	"kicked": "You've been voted out of the game.",
+	"banned": "You've been voted out of that lobby.",
+	"notice_match_started": "The match settings can only be changed between games.",
+	"notice_usage_match": "To play a series of games, type <b>/match &lt;number of games&gt;</b> (0 for no end).",
+	"notice_usage_rematch": "Type <b>/rematch on</b> to keep the same players for each game, or <b>/rematch off</b>.",
+	"notice_usage_tournament": "Tournaments: <b>/tournament create</b>, <b>register</b>, <b>unregister</b>, <b>start</b> or <b>bracket</b>.",
+	"notice_no_tournament": "There's no tournament here; the host can type <b>/tournament create</b> to start one.",
+	"notice_tournament_exists": "There's already a tournament here.",
+	"notice_registration_closed": "You can't sign up for the tournament now.",
+	"notice_tournament_started": "The tournament has already started.",
+	"notice_tournament_players": "The tournament needs at least two players.",
+	"notice_tournament_table": "The tournament decides who plays at this table.",
+	"tournament_registering": "There's a tournament here! Type <b>/tournament register</b> to sign up.",
+	"tournament_table": "Your table is ready - taking you there now...",
+	"peeking": "You can see everyone's cards now. Until the next game, you can only talk to other spectators (with <b>/s</b>).",
+	"notice_no_peeking": "Peeking isn't allowed in this lobby.",
+	"notice_cant_peek": "You can only peek while spectating a game.",
+	"notice_can_peek": "Type <b>/peek</b> to see everyone's cards for the rest of the game.",
+	"notice_peeking_on": "%s has allowed spectators to peek at everyone's cards.",
+	"notice_peeking_off": "%s has stopped spectators peeking at everyone's cards.",
+	"notice_peeking_chat": "You've seen everyone's cards, so you can only talk to spectators until the next game.",
+	"notice_usage_peeking": "Type <b>/peeking on</b> or <b>/peeking off</b>.",
+	"stale_card": "<span style='color:orange'>That card has moved - please try again.</span>",
 	"illegal_move": "Sorry, but the server has disconnected you for moving improperly. This is either a bug or you are trying to cheat.",
 	"message_spectating": "You are currently spectating; to join, type <b>/join</b>.",
-	"message_spectating_started": "You are spectating and can join once this round has finished.",
+	"message_spectating_started": "You are spectating; type <b>/join</b> to play in the next game.",
+	"queued": "You'll be sat down when the next game starts. Type <b>/unqueue</b> if you change your mind.",
 	"message_spectating_exploded": "You are out for this round.",
-	"message_playing": "Type <b>/start</b> to start the game, or <b>/leave</b> to spectate.",
+	"message_playing": "Type <b>/ready</b> when you're ready to play, or <b>/leave</b> to spectate.",
+	"countdown": "Everyone is ready - the game starts in %s seconds. Type <b>/unready</b> to wait.",
+	"countdown_cancelled": "The countdown has been stopped.",
 	"bcast_starting": "<span style='color:yellow'>The game is starting!</span>",
 	"bcast_new_game": "<span style='color:yellow'>A new game has started.</span>",
 	"bcast_no_nope": "Nope! There is nothing to Nope!",
 	"bcast_favour_cancel": "The favour was cancelled.",
-	"bcast_min_players": "There must be at least 2 players in a game.",
-	"bcast_max_players": "There cannot be more than 6 players in a game.",
-	"bcast_high_players": "<span style='color:orange'>You are playing with 6 players - the game will still work, but be aware that this is more than intended!</span>",
+	"bcast_min_players": "There aren't enough players to start the game yet.",
+	"bcast_max_players": "There are too many players to start the game.",
+	"bcast_high_players": "<span style='color:orange'>You are playing with a lot of players - the game will still work, but be aware that this is more than intended!</span>",
 	"must_defuse": "<span style='color:purple'>You must defuse the Detonating Cat.</span>",
 	"question_defuse_pos": "Where should the Detonating Cat be placed in the deck? (0 = on top)",
 	"question_favour_who": "Who do you want to ask for a favour?",
@@ -35,23 +78,15 @@ var strings = {
 	"question_steal_who": "Who would you like to steal a card from?",
 	"question_steal_what": "Which card would you like to steal?",
 	"conn_closed": "The connection to the server was lost.",
+	"session_expired": "You were disconnected for too long, so you've lost your place in the game.",
+	"reconnecting": "<span style='color:orange'>Lost the connection to the server; trying to reconnect...</span>",
+	"reconnected": "<span style='color:green'>Reconnected.</span>",
+	"resync": "<span style='color:orange'>Catching up with the game...</span>",
 	"bad_version": "The game server is running a different version of the game. If this problem persists, please try hard-reloading the page by pressing Ctrl+F5 or clearing your browser cache.",
 	"title_normal": "Detonating Cats",
 	"title_alert": "* YOUR TURN! * (Detonating Cats)"
 };
 
-var cards = [
-	"attack",
-	"defuse",
-	"exploding",
-	"favour",
-	"nope",
-	"random1",
-	"random2",
-	"random3",
-	"random4",
-	"random5",
-	"see3",
-	"shuffle",
-	"skip"
-];
+// Filled in from the server's cards.json
+var cards = [];
+var cardTypes = {};
@@ -116,3 +116,54 @@ function modalChoice(cb, question, options, exclude, transform) {
 		$(modalHud).css("opacity", "1");
 	}, 100);
 }
+
+function HTTPConn(url) {
+	// Looks enough like a WebSocket for our purposes, but works over plain
+	// HTTP, for networks that block websockets (see sse.go on the server)
+	this.readyState = WebSocket.CONNECTING;
+	this.onopen = null;
+	this.onclose = null;
+	this.onmessage = null;
+
+	let conn = this;
+	let id = null;
+	// Messages have to arrive in order, so only one is sent at a time
+	let sending = Promise.resolve();
+	let events = new EventSource(url);
+
+	events.addEventListener("conn", function(ev) {
+		id = ev.data;
+		conn.readyState = WebSocket.OPEN;
+		if (conn.onopen) conn.onopen();
+	});
+	events.onmessage = function(ev) {
+		if (conn.onmessage) conn.onmessage({data: ev.data});
+	};
+	events.onerror = function() {
+		// EventSource would try again by itself, but that would be a new
+		// connection as far as the server is concerned
+		conn.close();
+	};
+
+	this.send = function(msg) {
+		if (conn.readyState !== WebSocket.OPEN) {
+			return;
+		}
+		sending = sending.then(function() {
+			return fetch(url + "?conn=" + id, {method: "POST", body: msg});
+		}).then(function(res) {
+			if (!res.ok) conn.close();
+		}).catch(function() {
+			conn.close();
+		});
+	};
+
+	this.close = function() {
+		if (conn.readyState === WebSocket.CLOSED) {
+			return;
+		}
+		conn.readyState = WebSocket.CLOSED;
+		events.close();
+		if (conn.onclose) setTimeout(conn.onclose, 0);
+	};
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"slices"
+)
+
+// Spectators waiting for a seat in the next game. Sending "join" while a game
+// is on puts you in the queue (so does "queue"; "unqueue" takes you out), and
+// when the next game is set up the queue is sat down in order until the game
+// is full; anyone left over stays in the queue for the one after. Everyone is
+// sent "queue [<name>...]" whenever it changes.
+
+func (l *Lobby) enqueue(c *Client) {
+	l.clientsMu.Lock()
+	defer l.clientsMu.Unlock()
+
+	if l.banned[c.name] {
+		c.sendMsg("notice banned")
+		return
+	}
+	if slices.Contains(l.queue, c) {
+		return
+	}
+
+	l.queue = append(l.queue, c)
+	l.sendBcastRaw(l.queueList())
+}
+
+func (l *Lobby) dequeue(c *Client) {
+	l.clientsMu.Lock()
+	defer l.clientsMu.Unlock()
+
+	i := slices.Index(l.queue, c)
+	if i == -1 {
+		return
+	}
+
+	l.queue = slices.Delete(l.queue, i, i+1)
+	l.sendBcastRaw(l.queueList())
+}
+
+func (l *Lobby) queueList() string {
+	// /!\ needs a lock on l.clients
+	msg := "queue"
+	for _, c := range l.queue {
+		msg += " " + c.name
+	}
+	return msg
+}
+
+func (g *Game) seatQueue() {
+	// Sits down whoever is waiting, while there's room
+	l := g.lobby
+
+	l.clientsMu.Lock()
+	if len(l.queue) == 0 {
+		l.clientsMu.Unlock()
+		return
+	}
+
+	seats := conf().Game.MaxPlayers - len(g.players)
+	var seated []*Client
+	for len(l.queue) > 0 && len(seated) < seats {
+		c := l.queue[0]
+		l.queue = l.queue[1:]
+
+		if g.spectators[c] && !l.banned[c.name] {
+			seated = append(seated, c)
+		}
+	}
+	l.sendBcastRaw(l.queueList())
+	l.clientsMu.Unlock()
+
+	for _, c := range seated {
+		g.upgradePlayer(c)
+	}
+}
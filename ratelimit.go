@@ -0,0 +1,167 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Flood protection. Each connection, and each address, has token buckets for
+// messages in general, chat in particular and creating lobbies; go over and
+// you're disconnected. There are also caps on how many connections and
+// lobbies one address can have at once.
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) take(rate float64, burst int) bool {
+	// rate is tokens per second; a rate of 0 means no limit
+	if rate <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		if b.tokens > float64(burst) {
+			b.tokens = float64(burst)
+		}
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// One set of buckets, for either a connection or an address
+type rateLimiter struct {
+	messages   tokenBucket
+	chat       tokenBucket
+	newLobbies tokenBucket // Only used per address
+}
+
+func (r *rateLimiter) allow(msg string, scale float64) bool {
+	limits := conf().Limits
+
+	if !r.messages.take(limits.MessageRate*scale, int(float64(limits.MessageBurst)*scale)) {
+		return false
+	}
+	if isChat(msg) &&
+		!r.chat.take(limits.ChatRate*scale, int(float64(limits.ChatBurst)*scale)) {
+		return false
+	}
+	return true
+}
+
+type addrState struct {
+	rateLimiter
+	connections int
+	lobbies     int
+}
+
+var addrStates = make(map[string]*addrState)
+var addrStatesMu sync.Mutex
+
+func addrLimits(addr string) *addrState {
+	// /!\ needs a lock on addrStatesMu
+	s, ok := addrStates[addr]
+	if !ok {
+		s = new(addrState)
+		addrStates[addr] = s
+	}
+	return s
+}
+
+func forgetAddr(addr string) {
+	// /!\ needs a lock on addrStatesMu
+	if s := addrStates[addr]; s != nil && s.connections == 0 && s.lobbies == 0 {
+		delete(addrStates, addr)
+	}
+}
+
+func connectFrom(addr string) bool {
+	addrStatesMu.Lock()
+	defer addrStatesMu.Unlock()
+
+	s := addrLimits(addr)
+	if max := conf().Limits.MaxConnectionsPerAddr; max > 0 && s.connections >= max {
+		forgetAddr(addr)
+		return false
+	}
+	s.connections++
+	return true
+}
+
+func disconnectFrom(addr string) {
+	addrStatesMu.Lock()
+	defer addrStatesMu.Unlock()
+
+	addrLimits(addr).connections--
+	forgetAddr(addr)
+}
+
+func canCreateLobby(addr string) bool {
+	addrStatesMu.Lock()
+	defer addrStatesMu.Unlock()
+
+	max := conf().Limits.MaxLobbiesPerAddr
+	return max == 0 || addrLimits(addr).lobbies < max
+}
+
+func lobbyCreated(addr string) {
+	addrStatesMu.Lock()
+	defer addrStatesMu.Unlock()
+
+	addrLimits(addr).lobbies++
+}
+
+func lobbyClosed(addr string) {
+	addrStatesMu.Lock()
+	defer addrStatesMu.Unlock()
+
+	addrLimits(addr).lobbies--
+	forgetAddr(addr)
+}
+
+func (c *Client) allowed(msg string) bool {
+	// Checks a message against both the connection's and the address's limits
+	if !c.limits.allow(msg, 1) {
+		return false
+	}
+
+	addrStatesMu.Lock()
+	defer addrStatesMu.Unlock()
+	return addrLimits(c.addr).allow(msg, conf().Limits.AddrMultiplier)
+}
+
+func (c *Client) allowedNewLobby() bool {
+	limits := conf().Limits
+
+	addrStatesMu.Lock()
+	defer addrStatesMu.Unlock()
+	return addrLimits(c.addr).newLobbies.take(limits.LobbyRate, limits.LobbyBurst)
+}
+
+func (c *Client) kick(reason string) {
+	// Disconnects a client, telling them why; they can't come back
+	c.log().Warn("Disconnecting client", "addr", c.addr, "reason", reason)
+
+	c.endSession()
+	c.sendMsg("err " + reason)
+
+	c.sendMu.Lock()
+	c.token = ""
+	c.kicked = true
+	if c.send != nil {
+		// The writer sends what's left, then closes the connection
+		close(c.send)
+		c.send = nil
+	}
+	c.sendMu.Unlock()
+}
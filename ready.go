@@ -0,0 +1,150 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Getting a game going. Players who have sat down say when they're ready
+// ("ready", or "unready" to take it back); once everyone is, and there are
+// enough of them, there's a countdown of game.start_countdown ("countdown
+// <seconds>") before the game starts. Anyone sitting down, getting up or
+// changing their mind stops it ("countdown_cancelled").
+//
+// With game.auto_fill, spectators are sat down by themselves whenever there
+// is room, longest-waiting first, unless they've chosen to "leave".
+
+// A countdown that has run out, for the lobby's goroutine to start the game
+type countdownEnd struct {
+	game *Game
+	id   uint64
+}
+
+func (g *Game) setReady(c *Client, ready bool) {
+	if g.started || g.playerNumber(c) == -1 {
+		return
+	}
+
+	if g.lobby.table != nil {
+		// The tournament starts games here
+		c.sendMsg("notice tournament_table")
+		return
+	}
+
+	if g.ready[c] == ready {
+		return
+	}
+	g.ready[c] = ready
+
+	g.lobby.sendBcast("player_state " + g.playerState(c))
+	g.checkReady()
+}
+
+func (g *Game) checkReady() {
+	// Starts or stops the countdown, depending on whether everyone is ready
+	if g.started || g.lobby.table != nil {
+		return
+	}
+
+	limits := conf().Game
+	ready := len(g.players) >= limits.MinPlayers && len(g.players) <= limits.MaxPlayers
+	for _, player := range g.players {
+		if !g.ready[player] {
+			ready = false
+		}
+	}
+
+	g.lobby.clientsMu.Lock()
+	counting := g.countdown != nil
+	g.lobby.clientsMu.Unlock()
+
+	switch {
+	case ready && !counting:
+		wait := limits.StartCountdown.Duration
+		if wait <= 0 {
+			g.begin()
+			return
+		}
+
+		g.lobby.sendBcast("countdown " + strconv.Itoa(int(wait.Seconds())))
+
+		g.lobby.clientsMu.Lock()
+		g.countdownID++
+		end := countdownEnd{g, g.countdownID}
+		g.countdownEnds = time.Now().Add(wait)
+		g.countdown = time.AfterFunc(wait, func() {
+			// The game is started from the lobby's goroutine, not this one
+			g.lobby.countdowns <- end
+		})
+		g.lobby.clientsMu.Unlock()
+
+	case !ready && counting:
+		g.stopCountdown()
+		g.lobby.sendBcast("countdown_cancelled")
+	}
+}
+
+func (g *Game) stopCountdown() {
+	g.lobby.clientsMu.Lock()
+	defer g.lobby.clientsMu.Unlock()
+
+	if g.countdown != nil {
+		g.countdown.Stop()
+		g.countdown = nil
+	}
+	g.countdownID++
+}
+
+func (g *Game) countdownFinished(id uint64) {
+	// Called from the lobby's goroutine
+	g.lobby.clientsMu.Lock()
+	current := id == g.countdownID && g.countdown != nil && !g.started
+	if current {
+		g.countdown = nil
+	}
+	g.lobby.clientsMu.Unlock()
+
+	if !current {
+		// Cancelled just as it went off
+		return
+	}
+	g.begin()
+}
+
+func (g *Game) begin() {
+	if limits := conf().Game; limits.HighPlayers > 0 && len(g.players) >= limits.HighPlayers {
+		// Warning message
+		g.lobby.sendBcast("bcast high_players")
+	}
+
+	g.start()
+}
+
+func (g *Game) autoFill() {
+	// Sits spectators down while there are free seats
+	if !conf().Game.AutoFill || g.started || g.lobby.table != nil {
+		return
+	}
+
+	g.lobby.clientsMu.Lock()
+	waiting := []*Client{}
+	for spec := range g.spectators {
+		if !g.sittingOut[spec] && !g.lobby.banned[spec.name] {
+			waiting = append(waiting, spec)
+		}
+	}
+	g.lobby.clientsMu.Unlock()
+
+	// Whoever connected first has been waiting longest
+	sort.Slice(waiting, func(i, j int) bool {
+		return waiting[i].id < waiting[j].id
+	})
+
+	for _, client := range waiting {
+		if len(g.players) >= conf().Game.MaxPlayers {
+			break
+		}
+		g.upgradePlayer(client)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Every card in the game is described by a CardType. The built-in deck is
+// below; operators can replace it with their own by pointing -cards at a JSON
+// file, or a directory of them, containing a list of card types, e.g.
+//
+//	[
+//	  {"id": "bark", "name": "Bark!", "effect": "nope", "nopeable": true,
+//	   "count": {"default": 5}, "dealt": true, "weight": 20},
+//	  ...
+//	]
+//
+// The client fetches the same list from /cards.json, and expects an image
+// called assets/card_<id>.png for each card.
+
+const (
+	effectNone      = "none" // does nothing by itself, e.g. cat cards
+	effectExploding = "exploding"
+	effectDefuse    = "defuse"
+	effectNope      = "nope"
+	effectAttack    = "attack"
+	effectSkip      = "skip"
+	effectFavour    = "favour"
+	effectShuffle   = "shuffle"
+	effectSee3      = "see3"
+)
+
+var knownEffects = map[string]bool{
+	effectNone:      true,
+	effectExploding: true,
+	effectDefuse:    true,
+	effectNope:      true,
+	effectAttack:    true,
+	effectSkip:      true,
+	effectFavour:    true,
+	effectShuffle:   true,
+	effectSee3:      true,
+}
+
+// Effects which only change what GameState keeps, so can be undone by a NOPE
+var undoableEffects = map[string]bool{
+	effectNope:    true,
+	effectAttack:  true,
+	effectSkip:    true,
+	effectShuffle: true,
+}
+
+type CardType struct {
+	// Used on the wire and for the client's assets, so must be one word
+	ID string `json:"id"`
+
+	// Display name
+	Name string `json:"name"`
+
+	// How many are in the deck, by number of players ("2", "3", ...),
+	// falling back to "default"
+	Count map[string]int `json:"count"`
+
+	// Whether the card is in the deck when the hands are dealt, or only
+	// shuffled in afterwards (like the Detonating Cats)
+	Dealt bool `json:"dealt"`
+
+	// How many each player is given on top of their hand
+	Starting int `json:"starting"`
+
+	// Position when a hand is sorted, lowest first
+	Weight int `json:"weight"`
+
+	// Cat cards can only be played in pairs and threes
+	Cat bool `json:"cat"`
+
+	Nopeable bool `json:"nopeable"`
+
+	// What happens when the card is played (or drawn, for exploding)
+	Effect string `json:"effect"`
+}
+
+func (t *CardType) countFor(players int) int {
+	if n, ok := t.Count[strconv.Itoa(players)]; ok {
+		return n
+	}
+	return t.Count["default"]
+}
+
+type CardRegistry struct {
+	// In the order they were defined, which is the order the client gets them
+	types []*CardType
+	byID  map[string]*CardType
+}
+
+// The card types in use; replaced at startup if -cards is given
+var cardTypes = mustRegistry(defaultCardTypes)
+
+func newCardRegistry(types []*CardType) (*CardRegistry, error) {
+	r := &CardRegistry{byID: make(map[string]*CardType)}
+
+	exploding := false
+	for _, t := range types {
+		if t.ID == "" || strings.ContainsAny(t.ID, " \t\r\n") {
+			return nil, fmt.Errorf("card id %q must be a single word", t.ID)
+		}
+		if _, ok := r.byID[t.ID]; ok {
+			return nil, fmt.Errorf("card %s is defined twice", t.ID)
+		}
+		if t.Effect == "" {
+			t.Effect = effectNone
+		}
+		if !knownEffects[t.Effect] {
+			return nil, fmt.Errorf("card %s has unknown effect %q", t.ID, t.Effect)
+		}
+		if t.Nopeable && !undoableEffects[t.Effect] {
+			return nil, fmt.Errorf("card %s can't be NOPEd, because its effect can't be undone", t.ID)
+		}
+		if t.Name == "" {
+			t.Name = t.ID
+		}
+		if t.Effect == effectExploding {
+			exploding = true
+		}
+
+		r.types = append(r.types, t)
+		r.byID[t.ID] = t
+	}
+
+	if !exploding {
+		return nil, fmt.Errorf("no card has the %q effect, so the game could never end", effectExploding)
+	}
+
+	return r, nil
+}
+
+func mustRegistry(types []*CardType) *CardRegistry {
+	r, err := newCardRegistry(types)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func loadCardRegistry(path string) (*CardRegistry, error) {
+	// Loads card types from a JSON file, or every JSON file in a directory
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		files, err = filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+	}
+
+	var types []*CardType
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		var these []*CardType
+		if err := json.Unmarshal(data, &these); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		types = append(types, these...)
+	}
+
+	return newCardRegistry(types)
+}
+
+func (r *CardRegistry) get(id string) *CardType {
+	return r.byID[id]
+}
+
+func (r *CardRegistry) effect(id string) string {
+	t := r.byID[id]
+	if t == nil {
+		return ""
+	}
+	return t.Effect
+}
+
+func (r *CardRegistry) withEffect(effect string) (ids []string) {
+	for _, t := range r.types {
+		if t.Effect == effect {
+			ids = append(ids, t.ID)
+		}
+	}
+	return
+}
+
+var defaultCardTypes = []*CardType{
+	{ID: "exploding", Name: "Detonating Cat!", Effect: effectExploding,
+		Count: map[string]int{"default": 0, "2": 1, "3": 2, "4": 3, "5": 4, "6": 5}},
+	{ID: "defuse", Name: "Defuse", Effect: effectDefuse, Weight: 10, Starting: 1,
+		Count: map[string]int{"default": 0, "2": 4, "3": 3, "4": 2, "5": 1, "6": 0}},
+	{ID: "nope", Name: "Nope!", Effect: effectNope, Weight: 20, Nopeable: true, Dealt: true,
+		Count: map[string]int{"default": 5}},
+	{ID: "skip", Name: "Skip", Effect: effectSkip, Weight: 30, Nopeable: true, Dealt: true,
+		Count: map[string]int{"default": 4}},
+	{ID: "attack", Name: "Attack", Effect: effectAttack, Weight: 40, Nopeable: true, Dealt: true,
+		Count: map[string]int{"default": 4}},
+	{ID: "see3", Name: "See the Future (x3)", Effect: effectSee3, Weight: 50, Dealt: true,
+		Count: map[string]int{"default": 5}},
+	{ID: "shuffle", Name: "Shuffle", Effect: effectShuffle, Weight: 60, Nopeable: true, Dealt: true,
+		Count: map[string]int{"default": 4}},
+	{ID: "favour", Name: "Favour", Effect: effectFavour, Weight: 70, Dealt: true,
+		Count: map[string]int{"default": 4}},
+	{ID: "random1", Name: "BEANS Cat", Weight: 80, Cat: true, Dealt: true,
+		Count: map[string]int{"default": 4}},
+	{ID: "random2", Name: "CHUFFing Cat", Weight: 90, Cat: true, Dealt: true,
+		Count: map[string]int{"default": 4}},
+	{ID: "random3", Name: "Fridge Cat", Weight: 100, Cat: true, Dealt: true,
+		Count: map[string]int{"default": 4}},
+	{ID: "random4", Name: "Tacky Yack Cat", Weight: 110, Cat: true, Dealt: true,
+		Count: map[string]int{"default": 4}},
+	{ID: "random5", Name: "Bin Guitar Cat", Weight: 120, Cat: true, Dealt: true,
+		Count: map[string]int{"default": 4}},
+}
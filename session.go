@@ -0,0 +1,245 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Every message we send is numbered, per client, starting from 1:
+//
+//	<seq> <message>
+//
+// Clients acknowledge what they've seen with "ack <seq>", and can ask for
+// anything they missed with "resend <from> <to>". Once in a lobby, a client
+// is given a session token ("session <token>"); if the connection drops,
+// their place is kept for a little while, and a new connection can pick it
+// back up with "resume <token> <last seq seen>". The server answers with
+// "resumed <seq>", the number of the next message in the old session, and
+// sends everything after the last message seen. If too much has been missed,
+// the server sends "resync" followed by the whole state of the game instead.
+
+var sessions = make(map[string]*Client)
+var sessionsMu sync.Mutex
+
+func (c *Client) startSession() {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		// No resuming for this client, then
+		c.log().Error("Couldn't make a session token", "err", err)
+		return
+	}
+
+	c.token = hex.EncodeToString(token)
+
+	sessionsMu.Lock()
+	sessions[c.token] = c
+	sessionsMu.Unlock()
+
+	c.sendMsg("session " + c.token)
+}
+
+func findSession(token string) *Client {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	return sessions[token]
+}
+
+func (c *Client) endSession() {
+	if c.token == "" {
+		return
+	}
+
+	sessionsMu.Lock()
+	delete(sessions, c.token)
+	sessionsMu.Unlock()
+}
+
+func (c *Client) keep(line string) {
+	// Holds on to a sent message until it's acknowledged
+	// /!\ needs a lock on c.sendMu
+	c.unacked = append(c.unacked, line)
+
+	if len(c.unacked) > conf().Websocket.ResendBuffer {
+		// Too many; anything older will need a resync
+		c.unacked = c.unacked[1:]
+		c.forgotten++
+	}
+}
+
+func (c *Client) ack(seq uint64) {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	for len(c.unacked) > 0 && c.forgotten < seq {
+		c.unacked = c.unacked[1:]
+		c.forgotten++
+	}
+}
+
+func (c *Client) missed(from uint64, to uint64) ([]string, bool) {
+	// Finds messages from..to, as long as we still have them and
+	// there's room to send them again
+	// /!\ needs a lock on c.sendMu
+	if from <= c.forgotten || to > c.seq || from > to+1 || c.send == nil {
+		return nil, false
+	}
+
+	lines := c.unacked[from-c.forgotten-1 : to-c.forgotten]
+	if len(lines) > cap(c.send)-len(c.send) {
+		return nil, false
+	}
+	return lines, true
+}
+
+func (c *Client) replay(lines []string) {
+	// /!\ needs a lock on c.sendMu
+	for _, line := range lines {
+		c.send <- []byte(line)
+	}
+}
+
+func (c *Client) resend(from uint64, to uint64) {
+	c.sendMu.Lock()
+	lines, ok := c.missed(from, to)
+	c.replay(lines)
+	c.sendMu.Unlock()
+
+	if !ok {
+		c.resync()
+	}
+}
+
+func (c *Client) resync() {
+	// Starts the client again from scratch
+	if c.lobby == nil {
+		return
+	}
+
+	c.log().Info("Resyncing client")
+
+	c.lobby.clientsMu.Lock()
+	defer c.lobby.clientsMu.Unlock()
+
+	c.sendMsg("resync")
+	c.lobby.sendMatch(c)
+	if len(c.lobby.queue) > 0 {
+		c.sendMsg(c.lobby.queueList())
+	}
+	c.lobby.currentGame.resync(c)
+}
+
+func (c *Client) disconnected(conn connection) {
+	// Called when a connection dies; keeps the client's place in the lobby
+	// for a while, in case they come back
+	c.sendMu.Lock()
+
+	if c.conn != conn {
+		// Someone else has already picked up this session
+		c.sendMu.Unlock()
+		return
+	}
+
+	grace := conf().Websocket.ReconnectGrace.Duration
+	if c.token == "" || grace <= 0 {
+		c.sendMu.Unlock()
+		c.endSession()
+		c.lobby.unregister <- c
+		return
+	}
+
+	c.detached = true
+	close(c.send)
+	c.send = nil
+	c.grace = time.AfterFunc(grace, c.expire)
+	c.sendMu.Unlock()
+
+	c.log().Info("Waiting for client to reconnect", "grace", grace)
+}
+
+func (c *Client) expire() {
+	c.sendMu.Lock()
+	if !c.detached || c.gone {
+		c.sendMu.Unlock()
+		return
+	}
+	c.gone = true
+	c.sendMu.Unlock()
+
+	c.log().Info("Client didn't come back")
+	c.endSession()
+	c.lobby.unregister <- c
+}
+
+func (c *Client) quit() {
+	// Leaves the lobby as soon as the connection closes, without waiting
+	// for them to come back
+	c.endSession()
+
+	c.sendMu.Lock()
+	c.token = ""
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.sendMu.Unlock()
+}
+
+func (c *Client) closeSend() {
+	// For when we're finished with a client for good
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	c.gone = true
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	if c.send != nil {
+		close(c.send)
+		c.send = nil
+	}
+}
+
+func (c *Client) resume(conn *Client, last uint64) bool {
+	// Moves a new connection into this client, and sends it whatever it missed
+	c.sendMu.Lock()
+
+	if c.gone {
+		c.sendMu.Unlock()
+		return false
+	}
+
+	if c.grace != nil {
+		c.grace.Stop()
+		c.grace = nil
+	}
+
+	if !c.detached {
+		// We haven't noticed the old connection die yet, so kill it
+		close(c.send)
+		c.conn.Close()
+	}
+
+	c.conn = conn.conn
+	c.send = conn.send
+	c.addr = conn.addr
+	c.detached = false
+
+	next := last + 1
+	lines, ok := c.missed(next, c.seq)
+	if !ok {
+		next = c.seq + 1
+	}
+	// This goes out on the new connection before anything from us
+	conn.sendMsg("resumed " + strconv.FormatUint(next, 10))
+	c.replay(lines)
+	c.sendMu.Unlock()
+
+	c.log().Info("Client reconnected", "addr", c.addr, "replayed", ok)
+
+	if !ok {
+		c.resync()
+	}
+	return true
+}
@@ -0,0 +1,637 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The simulator plays lots of games between bots, headless, using the real
+// Game object. The bots speak the same protocol as the browser client: they
+// read whatever the server sends to them and reply with ordinary commands, so
+// anything the simulator finds is something a real player could run into.
+
+const (
+	// Buffer for each bot's outgoing messages - this only needs to hold
+	// what the server sends in response to a single action
+	simSendBuffer = 4096
+
+	// Give up on a game that takes this many actions, it's probably stuck
+	simMaxSteps = 5000
+)
+
+type simStrategy interface {
+	// What to do on our own turn; returns a command, e.g. "draw" or "play 3"
+	takeTurn(b *simBot, r *rand.Rand) string
+
+	// Whether to NOPE a card that somebody else has just played
+	wantsNope(b *simBot, card string, r *rand.Rand) bool
+
+	// Where to hide a defused Detonating Cat
+	defusePos(b *simBot, cardsLeft int, r *rand.Rand) int
+}
+
+var simStrategies = map[string]simStrategy{
+	"random":   simRandom{},
+	"cautious": simCautious{},
+	"passive":  simPassive{},
+}
+
+type simBot struct {
+	client   *Client
+	strategy string
+
+	hand     []string // card types
+	ids      []string // and the ID of each one
+	seen     []string // top of the deck, as far as we know
+	defusing bool
+
+	// Questions asked by the server that we haven't answered yet
+	question []string
+
+	// A card played by somebody else, which we might want to NOPE
+	noping string
+}
+
+func (b *simBot) readFromServer(msg string) {
+	parts := strings.Split(msg, " ")
+
+	switch parts[0] {
+	case "hand":
+		b.hand, b.ids = nil, nil
+		for _, card := range parts[1:] {
+			pair := strings.SplitN(card, ":", 2)
+			b.ids = append(b.ids, pair[0])
+			b.hand = append(b.hand, pair[1])
+		}
+	case "seen":
+		b.seen = parts[1:]
+	case "now_playing":
+		b.seen = nil
+	case "defusing":
+		b.defusing = true
+	case "drew", "drew_other":
+		if len(b.seen) > 0 {
+			b.seen = b.seen[1:]
+		}
+	case "played":
+		if parts[1] == b.client.name {
+			b.noping = ""
+		} else {
+			b.noping = parts[2]
+		}
+		if cardTypes.effect(parts[2]) == effectShuffle {
+			b.seen = nil
+		}
+	case "q":
+		b.question = parts[1:]
+	case "q_cancel":
+		b.question = nil
+	}
+}
+
+func (b *simBot) cardIndex(card string) int {
+	for i, c := range b.hand {
+		if c == card {
+			return i
+		}
+	}
+	return -1
+}
+
+func (b *simBot) cardWithEffect(effect string) int {
+	for i, c := range b.hand {
+		if cardTypes.effect(c) == effect {
+			return i
+		}
+	}
+	return -1
+}
+
+func (b *simBot) countCard(card string) (num int) {
+	for _, c := range b.hand {
+		if c == card {
+			num++
+		}
+	}
+	return
+}
+
+func (b *simBot) play(i int) string {
+	return "play " + b.ids[i]
+}
+
+func (b *simBot) playCombo(card string, num int) string {
+	cmd := "play_multiple"
+	for i, c := range b.hand {
+		if c == card && num > 0 {
+			cmd += " " + b.ids[i]
+			num--
+		}
+	}
+	return cmd
+}
+
+func (b *simBot) playable() (cards []int) {
+	// Single cards which make sense to play on our own turn
+	for i, card := range b.hand {
+		switch cardTypes.effect(card) {
+		case effectAttack, effectSkip, effectFavour, effectShuffle, effectSee3:
+			cards = append(cards, i)
+		}
+	}
+	return
+}
+
+func (b *simBot) combo() string {
+	// Returns a card we have two of, if there is one
+	for _, card := range b.hand {
+		if cardTypes.get(card).Cat && b.countCard(card) >= 2 {
+			return card
+		}
+	}
+	return ""
+}
+
+type simRandom struct{}
+
+func (simRandom) takeTurn(b *simBot, r *rand.Rand) string {
+	cards := b.playable()
+	if combo := b.combo(); combo != "" && r.Intn(3) == 0 {
+		return b.playCombo(combo, 2)
+	}
+	if len(cards) > 0 && r.Intn(2) == 0 {
+		return b.play(cards[r.Intn(len(cards))])
+	}
+	return "draw"
+}
+
+func (simRandom) wantsNope(b *simBot, card string, r *rand.Rand) bool {
+	return r.Intn(4) == 0
+}
+
+func (simRandom) defusePos(b *simBot, cardsLeft int, r *rand.Rand) int {
+	return r.Intn(cardsLeft + 1)
+}
+
+type simCautious struct{}
+
+func (simCautious) takeTurn(b *simBot, r *rand.Rand) string {
+	if len(b.seen) > 0 && cardTypes.effect(b.seen[0]) == effectExploding {
+		// Get out of the way
+		for _, effect := range []string{effectSkip, effectAttack, effectShuffle} {
+			if i := b.cardWithEffect(effect); i != -1 {
+				return b.play(i)
+			}
+		}
+	}
+
+	if b.seen == nil {
+		if i := b.cardWithEffect(effectSee3); i != -1 {
+			return b.play(i)
+		}
+	}
+
+	if combo := b.combo(); combo != "" && b.cardWithEffect(effectDefuse) == -1 {
+		// Go looking for a defuse
+		return b.playCombo(combo, 2)
+	}
+
+	return "draw"
+}
+
+func (simCautious) wantsNope(b *simBot, card string, r *rand.Rand) bool {
+	// Only NOPE attacks - keep the rest for emergencies
+	return cardTypes.effect(card) == effectAttack
+}
+
+func (simCautious) defusePos(b *simBot, cardsLeft int, r *rand.Rand) int {
+	// Put it right under the next player's nose
+	return 0
+}
+
+type simPassive struct{}
+
+func (simPassive) takeTurn(b *simBot, r *rand.Rand) string {
+	return "draw"
+}
+
+func (simPassive) wantsNope(b *simBot, card string, r *rand.Rand) bool {
+	return false
+}
+
+func (simPassive) defusePos(b *simBot, cardsLeft int, r *rand.Rand) int {
+	return r.Intn(cardsLeft + 1)
+}
+
+type simStats struct {
+	games  int
+	turns  int
+	stuck  int
+	winner map[string]int // by strategy
+	played map[string]int // by strategy - how many seats it has had
+
+	seatWins  []int
+	seatGames []int
+
+	// How many player-games included a card being played,
+	// and how many of those the player went on to win
+	cardGames map[string]int
+	cardWins  map[string]int
+
+	// Where defused Detonating Cats went back in (0 = on top)
+	defusePos map[int]int
+
+	illegal map[string]int
+}
+
+func newSimStats(players int) *simStats {
+	return &simStats{
+		winner:    make(map[string]int),
+		played:    make(map[string]int),
+		seatWins:  make([]int, players),
+		seatGames: make([]int, players),
+		cardGames: make(map[string]int),
+		cardWins:  make(map[string]int),
+		defusePos: make(map[int]int),
+		illegal:   make(map[string]int),
+	}
+}
+
+func (s *simStats) merge(o *simStats) {
+	s.games += o.games
+	s.turns += o.turns
+	s.stuck += o.stuck
+	for k, v := range o.winner {
+		s.winner[k] += v
+	}
+	for k, v := range o.played {
+		s.played[k] += v
+	}
+	for i := range o.seatWins {
+		s.seatWins[i] += o.seatWins[i]
+		s.seatGames[i] += o.seatGames[i]
+	}
+	for k, v := range o.cardGames {
+		s.cardGames[k] += v
+	}
+	for k, v := range o.cardWins {
+		s.cardWins[k] += v
+	}
+	for k, v := range o.defusePos {
+		s.defusePos[k] += v
+	}
+	for k, v := range o.illegal {
+		s.illegal[k] += v
+	}
+}
+
+func simulateGame(strategies []string, stats *simStats, r *rand.Rand) {
+	lobby := newLobby("simulation")
+	g := lobby.currentGame
+
+	bots := make(map[*Client]*simBot)
+	var order []*simBot
+
+	lobby.clientsMu.Lock()
+	for i, strategy := range strategies {
+		client := &Client{
+			name: "bot" + strconv.Itoa(i),
+			send: make(chan []byte, simSendBuffer),
+		}
+		bot := &simBot{client: client, strategy: strategy}
+		bots[client] = bot
+		order = append(order, bot)
+
+		lobby.clients[client] = true
+		g.addPlayer(client)
+	}
+	lobby.clientsMu.Unlock()
+
+	defer func() {
+		// The game will try to start a new one after a delay; make sure
+		// there's nobody left in the lobby for it to talk to
+		lobby.clientsMu.Lock()
+		lobby.clients = make(map[*Client]bool)
+		lobby.clientsMu.Unlock()
+	}()
+
+	for _, bot := range order {
+		lobby.readFromClient(bot.client, "join")
+	}
+	g.start()
+
+	seats := make(map[*simBot]int)
+	for i, player := range g.players {
+		seats[bots[player]] = i
+	}
+	cardsPlayed := make(map[*simBot]map[string]bool)
+	for _, bot := range order {
+		cardsPlayed[bot] = make(map[string]bool)
+	}
+
+	// Bring the bots up to date with everything the server has told them
+	drain := func() {
+		for _, bot := range order {
+			for len(bot.client.send) > 0 {
+				// Drop the sequence number
+				msg := strings.SplitN(string(<-bot.client.send), " ", 2)[1]
+				bot.readFromServer(msg)
+
+				// Record what happened, from one bot's perspective
+				if bot != order[0] {
+					continue
+				}
+				parts := strings.Split(msg, " ")
+				switch parts[0] {
+				case "now_playing":
+					stats.turns++
+				case "played":
+					cardsPlayed[bots[g.playerByName(parts[1])]][parts[2]] = true
+				case "played_multiple":
+					cardsPlayed[bots[g.playerByName(parts[1])]][parts[3]] = true
+				}
+			}
+		}
+	}
+
+	finished := false
+	for step := 0; step < simMaxSteps; step++ {
+		drain()
+
+		if len(g.players) <= 1 {
+			finished = true
+			break
+		}
+
+		for _, player := range g.players {
+			if g.hands[player] == nil {
+				stats.illegal["player_without_hand"]++
+			}
+		}
+		if g.deck.cardsLeft() == 0 && !g.defusing {
+			// (while defusing, the cat is about to go back in)
+			stats.illegal["empty_deck_with_players"]++
+			break
+		}
+
+		bot := simNextActor(g, bots, order, r)
+		if bot == nil {
+			stats.illegal["nobody_can_move"]++
+			break
+		}
+
+		strategy := simStrategies[bot.strategy]
+		var cmd string
+
+		switch {
+		case bot.question != nil:
+			cmd = simAnswer(g, bot, strategy, stats, r)
+			bot.question = nil
+		case bot.noping != "":
+			cmd = bot.play(bot.cardWithEffect(effectNope))
+			bot.noping = ""
+		case bot.defusing:
+			cmd = bot.play(bot.cardWithEffect(effectDefuse))
+			bot.defusing = false
+		default:
+			cmd = strategy.takeTurn(bot, r)
+		}
+
+		lobby.readFromClient(bot.client, cmd)
+	}
+
+	stats.games++
+	for _, bot := range order {
+		stats.played[bot.strategy]++
+		stats.seatGames[seats[bot]]++
+	}
+
+	if !finished {
+		stats.stuck++
+		return
+	}
+
+	winner := bots[g.players[0]]
+	stats.winner[winner.strategy]++
+	stats.seatWins[seats[winner]]++
+	for bot, cards := range cardsPlayed {
+		for card := range cards {
+			stats.cardGames[card]++
+			if bot == winner {
+				stats.cardWins[card]++
+			}
+		}
+	}
+}
+
+func simNextActor(g *Game, bots map[*Client]*simBot, order []*simBot, r *rand.Rand) *simBot {
+	// Questions first, because the game is waiting on them
+	for _, bot := range order {
+		if bot.question != nil {
+			return bot
+		}
+	}
+
+	current := bots[g.players[g.currentPlayer%len(g.players)]]
+
+	// Then a chance for everyone else to NOPE
+	for _, bot := range order {
+		if bot.noping == "" {
+			continue
+		}
+		if bot == current || g.playerNumber(bot.client) == -1 || bot.cardWithEffect(effectNope) == -1 {
+			bot.noping = ""
+			continue
+		}
+		if cardTypes.get(bot.noping).Nopeable {
+			if simStrategies[bot.strategy].wantsNope(bot, bot.noping, r) {
+				return bot
+			}
+		}
+		bot.noping = ""
+	}
+
+	return current
+}
+
+func simAnswer(g *Game, bot *simBot, strategy simStrategy, stats *simStats, r *rand.Rand) string {
+	question := bot.question[0]
+
+	switch question {
+	case "defuse_pos":
+		pos := strategy.defusePos(bot, g.deck.cardsLeft(), r)
+		stats.defusePos[pos]++
+		return "a defuse_pos " + strconv.Itoa(pos)
+	case "favour_who", "random_who", "steal_who":
+		var targets []*Client
+		for _, player := range g.players {
+			if player != bot.client {
+				targets = append(targets, player)
+			}
+		}
+		return "a " + question + " " + targets[r.Intn(len(targets))].name
+	case "favour_what":
+		if len(bot.hand) == 0 {
+			// Nothing to give, so the favour can never complete
+			stats.illegal["favour_from_empty_hand"]++
+			return "a favour_what 0"
+		}
+		return "a favour_what " + bot.ids[r.Intn(len(bot.ids))]
+	case "steal_what":
+		if defuses := cardTypes.withEffect(effectDefuse); len(defuses) > 0 {
+			return "a steal_what " + defuses[0]
+		}
+		return "a steal_what " + cardTypes.types[r.Intn(len(cardTypes.types))].ID
+	}
+
+	return "a " + question
+}
+
+func simulate(args []string) {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	games := fs.Int("games", 1000, "number of games to play")
+	players := fs.Int("players", 4, "players per game")
+	workers := fs.Int("workers", runtime.NumCPU(), "games to run in parallel")
+	strategyList := fs.String("strategies", "random,cautious",
+		"comma-separated bot strategies, assigned to seats in turn (random, cautious, passive)")
+	cards := fs.String("cards", "", "JSON file or directory of card definitions (default: built-in deck)")
+	fs.Parse(args)
+
+	if *cards != "" {
+		registry, err := loadCardRegistry(*cards)
+		if err == nil {
+			err = conf().checkCards(registry)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "couldn't load cards:", err)
+			os.Exit(2)
+		}
+		cardTypes = registry
+	}
+
+	if limits := conf().Game; *players < limits.MinPlayers || *players > limits.MaxPlayers {
+		fmt.Fprintf(os.Stderr, "players must be between %d and %d\n", limits.MinPlayers, limits.MaxPlayers)
+		os.Exit(2)
+	}
+
+	var strategies []string
+	for i, names := 0, strings.Split(*strategyList, ","); i < *players; i++ {
+		name := strings.TrimSpace(names[i%len(names)])
+		if _, ok := simStrategies[name]; !ok {
+			fmt.Fprintln(os.Stderr, "unknown strategy:", name)
+			os.Exit(2)
+		}
+		strategies = append(strategies, name)
+	}
+
+	// The game logs every message, which we really don't want here
+	log.SetOutput(ioutil.Discard)
+
+	total := newSimStats(*players)
+	var totalMu sync.Mutex
+	var wg sync.WaitGroup
+
+	jobs := make(chan int)
+	for w := 0; w < *workers; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+
+			r := rand.New(rand.NewSource(seed))
+			stats := newSimStats(*players)
+			for range jobs {
+				// Rotate the strategies so nobody gets an advantage from being first to join
+				shift := r.Intn(len(strategies))
+				seats := make([]string, 0, len(strategies))
+				seats = append(seats, strategies[shift:]...)
+				seats = append(seats, strategies[:shift]...)
+				simulateGame(seats, stats, r)
+			}
+
+			totalMu.Lock()
+			total.merge(stats)
+			totalMu.Unlock()
+		}(rand.Int63() + int64(w))
+	}
+	for i := 0; i < *games; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	total.report(os.Stdout)
+}
+
+func (s *simStats) report(w io.Writer) {
+	finished := s.games - s.stuck
+
+	fmt.Fprintf(w, "%d games played, %d finished\n", s.games, finished)
+	if finished == 0 {
+		return
+	}
+	fmt.Fprintf(w, "average length: %.1f turns\n\n", float64(s.turns)/float64(s.games))
+
+	fmt.Fprintln(w, "win rate by strategy:")
+	var names []string
+	for name := range s.played {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-10s %5.1f%%\n", name, percent(s.winner[name], s.played[name]))
+	}
+
+	fmt.Fprintln(w, "\nwin rate by seat:")
+	for i := range s.seatWins {
+		fmt.Fprintf(w, "  %-10d %5.1f%%\n", i+1, percent(s.seatWins[i], s.seatGames[i]))
+	}
+
+	// A card 'matters' if the players who play it win more often than the average
+	fmt.Fprintln(w, "\nwin rate when played:")
+	var cards []string
+	for card := range s.cardGames {
+		cards = append(cards, card)
+	}
+	sort.Strings(cards)
+	for _, card := range cards {
+		fmt.Fprintf(w, "  %-10s %5.1f%% (played in %d player-games)\n", card,
+			percent(s.cardWins[card], s.cardGames[card]), s.cardGames[card])
+	}
+
+	fmt.Fprintln(w, "\ndefused cat position (0 = top):")
+	var positions []int
+	defused := 0
+	for pos, n := range s.defusePos {
+		positions = append(positions, pos)
+		defused += n
+	}
+	sort.Ints(positions)
+	for _, pos := range positions {
+		fmt.Fprintf(w, "  %-10d %5.1f%%\n", pos, percent(s.defusePos[pos], defused))
+	}
+
+	if len(s.illegal) > 0 || s.stuck > 0 {
+		fmt.Fprintln(w, "\n!!! illegal states:")
+		for state, n := range s.illegal {
+			fmt.Fprintf(w, "  %-24s %d\n", state, n)
+		}
+		fmt.Fprintf(w, "  %-24s %d\n", "unfinished_games", s.stuck)
+	}
+}
+
+func percent(n, of int) float64 {
+	if of == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(of)
+}
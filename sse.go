@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The same protocol as /ws, over plain HTTP, for networks that don't let
+// websockets through. A GET to /sse opens a stream of Server-Sent Events:
+// first "event: conn" with an ID for the connection, then each message from
+// the server as a "data:" line. Messages to the server are POSTed to
+// /sse?conn=<id>, one per request, in order. Closing the stream closes the
+// connection, as with a websocket.
+
+type sseConn struct {
+	id      string
+	done    chan struct{}
+	closing sync.Once
+
+	// Messages are dealt with one at a time, like on a websocket, and the
+	// client they're from changes if the connection resumes a session
+	readMu  sync.Mutex
+	client  *Client
+	lobbies map[string]*Lobby
+}
+
+var sseConns = make(map[string]*sseConn)
+var sseConnsMu sync.Mutex
+
+func (s *sseConn) Close() error {
+	s.closing.Do(func() {
+		close(s.done)
+	})
+	return nil
+}
+
+func findSSEConn(id string) *sseConn {
+	sseConnsMu.Lock()
+	defer sseConnsMu.Unlock()
+	return sseConns[id]
+}
+
+func serveSSE(w http.ResponseWriter, r *http.Request, lobbies map[string]*Lobby) {
+	if !upgrader.CheckOrigin(r) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		streamSSE(w, r, lobbies)
+	case http.MethodPost:
+		postSSE(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func streamSSE(w http.ResponseWriter, r *http.Request, lobbies map[string]*Lobby) {
+	// Counterpart to readPump and writePump together
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming isn't supported", http.StatusInternalServerError)
+		return
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		http.Error(w, "Couldn't make a connection ID", http.StatusInternalServerError)
+		return
+	}
+
+	s := &sseConn{
+		id:      hex.EncodeToString(id),
+		done:    make(chan struct{}),
+		lobbies: lobbies,
+	}
+	send := make(chan []byte, conf().Websocket.SendBuffer)
+	client := &Client{
+		conn: s,
+		send: send,
+		addr: clientAddr(r),
+		id:   atomic.AddUint64(&lastClientID, 1),
+	}
+	s.client = client
+	client.log().Info("New connection", "addr", client.addr, "scheme", requestScheme(r), "transport", "sse")
+
+	sseConnsMu.Lock()
+	sseConns[s.id] = s
+	sseConnsMu.Unlock()
+
+	defer func() {
+		// Clean up
+		s.Close()
+
+		sseConnsMu.Lock()
+		delete(sseConns, s.id)
+		sseConnsMu.Unlock()
+
+		s.readMu.Lock()
+		c := s.client
+		s.readMu.Unlock()
+
+		if c.lobby != nil {
+			c.disconnected(s)
+		}
+
+		if r := recover(); r != nil {
+			c.dieGracefully(r)
+		}
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "event: conn\ndata: "+s.id+"\n\n")
+	flusher.Flush()
+
+	// Send the server version on connect
+	client.sendMsg("version " + strconv.Itoa(REVISION))
+
+	if connectFrom(client.addr) {
+		defer disconnectFrom(client.addr)
+	} else {
+		client.kick("too_many_connections")
+	}
+
+	ticker := time.NewTicker(conf().pingPeriod())
+	defer ticker.Stop()
+
+	writer := http.NewResponseController(w)
+
+	for {
+		select {
+		case message, ok := <-send:
+			if !ok {
+				client.log().Debug("Write channel closed")
+				return
+			}
+			fmt.Fprint(w, "data: "+string(message)+"\n\n")
+
+		case <-ticker.C:
+			// Proxies tend to drop streams that go quiet
+			fmt.Fprint(w, ": ping\n\n")
+
+		case <-s.done:
+			return
+
+		case <-r.Context().Done():
+			client.log().Info("Connection closed", "addr", client.addr)
+			return
+		}
+
+		writer.SetWriteDeadline(time.Now().Add(conf().Websocket.WriteWait.Duration))
+		if err := writer.Flush(); err != nil {
+			client.log().Info("Disconnected on write", "err", err)
+			return
+		}
+	}
+}
+
+func postSSE(w http.ResponseWriter, r *http.Request) {
+	s := findSSEConn(r.URL.Query().Get("conn"))
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, conf().Websocket.MaxMessageSize))
+	if err != nil {
+		http.Error(w, "Message too long", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	s.readMu.Lock()
+	defer s.readMu.Unlock()
+
+	select {
+	case <-s.done:
+		// Too late
+		http.NotFound(w, r)
+		return
+	default:
+	}
+
+	s.client = s.client.received(string(body), s.lobbies)
+	w.WriteHeader(http.StatusNoContent)
+}
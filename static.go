@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The client is built into the binary, so the server can be run from
+// anywhere. Every response carries a hash of its contents as an ETag, so
+// browsers always revalidate instead of running stale code, and the scripts
+// and stylesheets referenced by index.html get the hash in their URL too so
+// they can be cached for good.
+
+//go:embed public_html
+var embeddedClient embed.FS
+
+// References to scripts and stylesheets in index.html, which we add hashes to
+var staticRefs = regexp.MustCompile(`(src|href)="([^":?]+\.(?:js|css))(?:\?[^"]*)?"`)
+
+type staticFile struct {
+	data []byte
+	hash string
+}
+
+type staticFiles struct {
+	fsys fs.FS
+
+	// When serving from the binary the files never change,
+	// so we only need to read and hash them once
+	cache   map[string]*staticFile
+	cacheMu sync.Mutex
+}
+
+func newStaticFiles(dir string) (*staticFiles, error) {
+	if dir != "" {
+		// Serve from disk, for development
+		if _, err := os.Stat(path.Join(dir, "index.html")); err != nil {
+			return nil, err
+		}
+		return &staticFiles{fsys: os.DirFS(dir)}, nil
+	}
+
+	sub, err := fs.Sub(embeddedClient, "public_html")
+	if err != nil {
+		return nil, err
+	}
+	return &staticFiles{fsys: sub, cache: make(map[string]*staticFile)}, nil
+}
+
+func (s *staticFiles) get(name string) (*staticFile, error) {
+	if s.cache != nil {
+		s.cacheMu.Lock()
+		file, ok := s.cache[name]
+		s.cacheMu.Unlock()
+
+		if ok {
+			return file, nil
+		}
+	}
+
+	data, err := fs.ReadFile(s.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "index.html" {
+		data = s.rewriteIndex(data)
+	}
+
+	sum := sha256.Sum256(data)
+	file := &staticFile{data: data, hash: hex.EncodeToString(sum[:8])}
+
+	if s.cache != nil {
+		s.cacheMu.Lock()
+		s.cache[name] = file
+		s.cacheMu.Unlock()
+	}
+	return file, nil
+}
+
+func (s *staticFiles) rewriteIndex(data []byte) []byte {
+	// Point index.html at the current versions of everything it loads
+	data = bytes.ReplaceAll(data, []byte("{{REVISION}}"), []byte(strconv.Itoa(REVISION)))
+
+	return staticRefs.ReplaceAllFunc(data, func(ref []byte) []byte {
+		match := staticRefs.FindSubmatch(ref)
+		name := string(match[2])
+
+		if strings.Contains(name, "//") {
+			// Somebody else's file
+			return ref
+		}
+
+		file, err := s.get(strings.TrimPrefix(path.Clean("/"+name), "/"))
+		if err != nil {
+			return ref
+		}
+		return []byte(string(match[1]) + `="` + name + "?v=" + file.hash + `"`)
+	})
+}
+
+func (s *staticFiles) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "index.html"
+	}
+
+	file, err := s.get(name)
+	if err != nil {
+		// Includes directories, which we don't list
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("ETag", `"`+file.hash+`"`)
+	if r.URL.Query().Get("v") == file.hash {
+		// The URL changes whenever the file does
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+
+	// ServeContent deals with If-None-Match for us
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(file.data))
+}
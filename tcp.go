@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The same protocol as /ws, as plain lines of text over TCP, for playing from
+// a terminal (telnet, netcat) or scripting test games. It's switched on by
+// server.tcp_listen. Messages from the server come without their numbers, as
+// TCP doesn't lose any, and each is followed by a description in English,
+// after " # ", where there is one. On top of the usual commands:
+//
+//	help              what you can type
+//	describe on|off   whether to add descriptions (off is easier for scripts)
+
+type tcpConn struct {
+	net.Conn
+
+	writeMu  sync.Mutex
+	describe atomic.Bool
+}
+
+const tcpHelp = `Commands (the same as the web client sends):
+  join_lobby <lobby> <name>    join (or make) a lobby
+` + commandHelp + `
+Just here:
+  help                         this
+  describe on|off              describe what happens in English, or not`
+
+// Everything you can do once in a lobby (also used by the terminal client)
+const commandHelp = `  join, leave                  sit down for the next game, or get up
+  ready, unready               say whether you're ready to start
+  draw                         draw a card, ending your turn
+  play <id>                    play a card from your hand, by its number
+  play_multiple <id> <id>...   play a combo of matching cards
+  a <question> <answer>        answer a question, e.g. "a favour_who bob"
+  sort                         sort your hand
+  chat <text>, me <text>       talk to the lobby
+  w <name> <text>              whisper to someone
+  pause, unpause, vote_kick <name>, vote yes|no
+  match <games>, rematch on|off, scores, peek, peeking on|off
+  tournament create|register|unregister|start|bracket
+  quit                         leave the lobby`
+
+func listenTCP(addr string, lobbies map[string]*Lobby) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal("Couldn't listen for terminals: ", err)
+	}
+	slog.Info("Now listening for terminals", "listen", addr)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			slog.Warn("Couldn't accept connection", "err", err)
+			continue
+		}
+		go serveTCP(conn, lobbies)
+	}
+}
+
+func serveTCP(netConn net.Conn, lobbies map[string]*Lobby) {
+	// Counterpart to readPump
+	conn := &tcpConn{Conn: netConn}
+	conn.describe.Store(true)
+
+	addr, _, err := net.SplitHostPort(netConn.RemoteAddr().String())
+	if err != nil {
+		addr = netConn.RemoteAddr().String()
+	}
+
+	send := make(chan []byte, conf().Websocket.SendBuffer)
+	c := &Client{
+		conn: conn,
+		send: send,
+		addr: addr,
+		id:   atomic.AddUint64(&lastClientID, 1),
+	}
+	c.log().Info("New connection", "addr", c.addr, "transport", "tcp")
+
+	defer func() {
+		// Clean up
+		conn.Close()
+		if c.lobby != nil {
+			c.disconnected(conn)
+		}
+
+		if r := recover(); r != nil {
+			c.dieGracefully(r)
+		}
+	}()
+
+	conn.write("Welcome to Detonating Cats! Type \"join_lobby <lobby> <name>\" to play, or \"help\".")
+	go c.tcpWritePump(conn, send)
+
+	if connectFrom(addr) {
+		defer disconnectFrom(addr)
+	} else {
+		c.kick("too_many_connections")
+	}
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 1024), int(conf().Websocket.MaxMessageSize))
+
+	for scanner.Scan() {
+		message := strings.TrimRight(scanner.Text(), "\r")
+		fields := strings.Fields(message)
+
+		switch {
+		case len(fields) == 1 && fields[0] == "help":
+			conn.write(tcpHelp)
+		case len(fields) == 2 && fields[0] == "describe":
+			conn.describe.Store(fields[1] != "off")
+		default:
+			c = c.received(message, lobbies)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		c.log().Info("Connection closed", "addr", c.addr, "err", err)
+	}
+}
+
+func (c *Client) tcpWritePump(conn *tcpConn, send chan []byte) {
+	// Counterpart to serveTCP, like writePump
+	defer func() {
+		conn.Close()
+
+		if r := recover(); r != nil {
+			c.dieGracefully(r)
+		}
+	}()
+
+	// Send the server version on connect
+	c.sendMsg("version " + strconv.Itoa(REVISION))
+
+	for message := range send {
+		// No need for the number
+		_, msg, _ := strings.Cut(string(message), " ")
+
+		if conn.describe.Load() {
+			if description := describe(msg); description != "" {
+				msg += " # " + description
+			}
+		}
+
+		if err := conn.write(msg); err != nil {
+			c.log().Info("Disconnected on write", "err", err)
+			return
+		}
+	}
+
+	c.log().Debug("Write channel closed")
+}
+
+func (conn *tcpConn) write(text string) error {
+	conn.writeMu.Lock()
+	defer conn.writeMu.Unlock()
+
+	// Nothing from anyone else should reach the terminal as anything but text
+	text = strings.Map(func(r rune) rune {
+		if r != '\n' && isControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+
+	conn.SetWriteDeadline(time.Now().Add(conf().Websocket.WriteWait.Duration))
+	_, err := conn.Write([]byte(text + "\n"))
+	return err
+}
+
+// Questions, and how to answer them
+var tcpQuestions = map[string]string{
+	"defuse_pos":  "Where should the Detonating Cat go back in the deck? \"a defuse_pos <n>\", 0 is the top",
+	"favour_who":  "Who do you want to ask for a favour? \"a favour_who <name>\"",
+	"random_who":  "Who do you want a random card from? \"a random_who <name>\"",
+	"steal_who":   "Who do you want to steal a card from? \"a steal_who <name>\"",
+	"favour_what": "%s wants a favour: which card will you give them? \"a favour_what <id>\"",
+	"steal_what":  "Which card do you want to steal? \"a steal_what <card>\"",
+}
+
+func describe(msg string) string {
+	// What a message from the server means, in English, or "" if it goes
+	// without saying
+	f := strings.Fields(msg)
+	if len(f) == 0 {
+		return ""
+	}
+	arg := func(i int) string {
+		if i < len(f) {
+			return f[i]
+		}
+		return ""
+	}
+	rest := func(i int) string {
+		if i < len(f) {
+			return strings.Join(f[i:], " ")
+		}
+		return ""
+	}
+
+	switch f[0] {
+	case "history":
+		if d := describe(rest(1)); d != "" {
+			return "earlier: " + d
+		}
+	case "joins":
+		return arg(1) + " joined the lobby"
+	case "parts":
+		return arg(1) + " left the lobby"
+	case "upgrades":
+		return arg(1) + " sat down to play"
+	case "downgrades":
+		return arg(1) + " is watching"
+	case "host":
+		return arg(1) + " is the host"
+	case "players":
+		if len(f) == 1 {
+			return "Nobody is playing"
+		}
+		var players []string
+		for _, state := range f[1:] {
+			players = append(players, describePlayer(state))
+		}
+		return "Players: " + strings.Join(players, "; ")
+	case "player_state":
+		return describePlayer(arg(1))
+	case "spectators":
+		if len(f) == 1 {
+			return "Nobody is watching"
+		}
+		return "Watching: " + strings.Join(f[1:], ", ")
+	case "countdown":
+		return "Starting in " + arg(1) + " seconds"
+	case "countdown_cancelled":
+		return "Not starting after all"
+	case "now_playing":
+		return "It's " + arg(1) + "'s turn"
+	case "turns_left":
+		return arg(1) + " turn(s) to take"
+	case "cards_left":
+		return arg(1) + " cards left in the deck"
+	case "hand":
+		if len(f) == 1 {
+			return "Your hand is empty"
+		}
+		var cards []string
+		for _, card := range f[1:] {
+			cards = append(cards, describeCard(card))
+		}
+		return "Your hand: " + strings.Join(cards, ", ")
+	case "drew":
+		return "You drew " + describeCard(arg(1))
+	case "drew_other":
+		return arg(1) + " drew a card"
+	case "played":
+		return arg(1) + " played " + describeCard(arg(2))
+	case "played_multiple":
+		return arg(1) + " played " + arg(2) + " " + describeCard(arg(3)) + " cards"
+	case "discard":
+		return "On the discard pile: " + describeCard(arg(1))
+	case "exploded":
+		return arg(1) + " exploded!"
+	case "defusing":
+		return "You drew a Detonating Cat! Play a Defuse, \"play <id>\", to put it back"
+	case "seen":
+		var cards []string
+		for _, card := range f[1:] {
+			cards = append(cards, describeCard(card))
+		}
+		return "The top of the deck: " + strings.Join(cards, ", ")
+	case "favoured":
+		return arg(1) + " asked " + arg(2) + " for a favour"
+	case "favour_complete":
+		return arg(2) + " did " + arg(1) + " a favour"
+	case "favour_recv":
+		return arg(1) + " gave you " + describeCard(arg(2))
+	case "favour_gave":
+		return "You gave " + arg(1) + " " + describeCard(arg(2))
+	case "randomed":
+		return arg(1) + " took a random card from " + arg(2)
+	case "random_n":
+		return arg(1) + " wanted a random card from " + arg(2) + ", who had none"
+	case "random_recv":
+		return "You took " + describeCard(arg(2)) + " from " + arg(1)
+	case "random_gave":
+		return arg(1) + " took your " + describeCard(arg(2))
+	case "steal_y":
+		return arg(1) + " stole " + describeCard(arg(3)) + " from " + arg(2)
+	case "steal_n":
+		return arg(1) + " wanted " + describeCard(arg(3)) + " from " + arg(2) + ", who had none"
+	case "q":
+		if q, ok := tcpQuestions[arg(1)]; ok {
+			return strings.Replace(q, "%s", arg(2), 1)
+		}
+	case "q_cancel":
+		return "Never mind"
+	case "wins":
+		return arg(1) + " wins!"
+	case "chat":
+		return "<" + arg(1) + "> " + rest(2)
+	case "chat_me":
+		return "* " + arg(1) + " " + rest(2)
+	case "chat_team":
+		return "[" + arg(1) + "] <" + arg(2) + "> " + rest(3)
+	case "whisper":
+		return arg(1) + " whispers to " + arg(2) + ": " + rest(3)
+	case "paused":
+		return "The game is paused"
+	case "unpaused":
+		return "The game is back on"
+	case "scores":
+		return "Scores: " + strings.Join(f[1:], ", ")
+	case "match_winner":
+		return rest(2) + " won the match, with " + arg(1) + " points"
+	case "tournament_table":
+		return "Your table is ready: connect again, and join lobby " + arg(1)
+	case "tournament_winner":
+		return arg(1) + " won the tournament!"
+	case "err", "notice", "bcast", "message":
+		// These are looked up in the web client's strings; the key is
+		// readable enough
+		return strings.ReplaceAll(arg(1), "_", " ") + strings.TrimRight(" "+rest(2), " ")
+	}
+	return ""
+}
+
+func describePlayer(state string) string {
+	// From name:cards:state:turns, as in Game.playerList
+	parts := strings.Split(state, ":")
+	if len(parts) != 4 {
+		return state
+	}
+	desc := parts[0] + ", " + parts[2] + ", " + parts[1] + " cards"
+	if parts[3] != "0" {
+		desc += ", playing"
+	}
+	return desc
+}
+
+func describeCard(card string) string {
+	// A card's name, with its number if it has one
+	id, name, numbered := strings.Cut(card, ":")
+	if !numbered {
+		name = card
+	}
+
+	if t := cardTypes.get(name); t != nil && t.Name != "" {
+		name = t.Name
+	}
+	if numbered {
+		return name + " (" + id + ")"
+	}
+	return name
+}
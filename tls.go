@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// How often to look for a renewed certificate
+const certCheckInterval = 10 * time.Second
+
+// Serves the certificate from disk, picking up a new one whenever the files
+// change, so that certificates can be renewed without a restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	cert    *tls.Certificate
+	modTime time.Time
+	mu      sync.RWMutex
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile}
+
+	// Fail straight away if the certificate is no good
+	if err := cr.load(); err != nil {
+		return nil, err
+	}
+
+	go cr.watch()
+	return cr, nil
+}
+
+func (cr *certReloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{cr.certFile, cr.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (cr *certReloader) load() error {
+	modTime, err := cr.lastModified()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.modTime = modTime
+	cr.mu.Unlock()
+	return nil
+}
+
+func (cr *certReloader) watch() {
+	for range time.Tick(certCheckInterval) {
+		modTime, err := cr.lastModified()
+
+		cr.mu.RLock()
+		changed := err == nil && !modTime.Equal(cr.modTime)
+		cr.mu.RUnlock()
+
+		if !changed {
+			continue
+		}
+
+		// The files might be half-written, in which case we'll try again next time;
+		// either way, keep using the old certificate until we have a good one
+		if err := cr.load(); err != nil {
+			slog.Error("Couldn't reload TLS certificate", "err", err)
+			continue
+		}
+		slog.Info("Reloaded TLS certificate")
+	}
+}
+
+func (cr *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+func listenAndServeTLS(c *Config, handler http.Handler) error {
+	cr, err := newCertReloader(c.Server.TLSCert, c.Server.TLSKey)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:    c.Server.Listen,
+		Handler: handler,
+		TLSConfig: &tls.Config{
+			GetCertificate: cr.getCertificate,
+			MinVersion:     tls.VersionTLS12,
+		},
+	}
+
+	if c.Server.RedirectListen != "" {
+		go func() {
+			slog.Info("Redirecting to HTTPS", "listen", c.Server.RedirectListen)
+			log.Fatal(http.ListenAndServe(c.Server.RedirectListen, redirectToTLS(c.Server.Listen)))
+		}()
+	}
+
+	// The certificate comes from GetCertificate
+	return server.ListenAndServeTLS("", "")
+}
+
+func redirectToTLS(tlsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
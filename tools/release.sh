@@ -1,9 +1,8 @@
 #!/bin/bash
 
-# We store the current revision number in a few of the game's source files,
-# so that on startup, the client can check that everything is correct
-# (web caching is flaky)
-# This script updates all of those files at once (HACK)
+# The revision number lets the client check that it's talking to the same
+# version of the server. The server writes it into the client when serving
+# index.html, so wwwcats.go is the only place it needs changing.
 
 # We can safely get the revision number from wwwcats.go this way
 # because it won't compile if there is more than one 'var REVISION'
@@ -17,7 +16,5 @@ VERSION=$(egrep "^var REVISION = " wwwcats.go | rev | cut -d' ' -f 1 | rev)
 NEW_VERSION=$((VERSION+1))
 
 sed -i "s/var REVISION = $VERSION/var REVISION = $NEW_VERSION/" wwwcats.go
-sed -i "s/var REVISION = \"$VERSION\";/var REVISION = \"$NEW_VERSION\";/" public_html/init.js
-sed -i "s/id=\"REVISION\" value=\"$VERSION\"/id=\"REVISION\" value=\"$NEW_VERSION\"/" public_html/index.html
 
 echo "Updated to version $NEW_VERSION"
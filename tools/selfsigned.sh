@@ -0,0 +1,23 @@
+#!/bin/bash
+
+# Makes a self-signed certificate for trying out HTTPS locally:
+#   tools/selfsigned.sh && ./wwwcats -l :8443 -config tls-test.json
+# Your browser will complain about it, which is expected.
+
+DAYS=${DAYS:-30}
+
+openssl req -x509 -newkey rsa:2048 -nodes -days "$DAYS" \
+	-keyout localhost.key -out localhost.crt \
+	-subj "/CN=localhost" -addext "subjectAltName=DNS:localhost,IP:127.0.0.1" || exit 1
+
+cat > tls-test.json <<END
+{
+	"server": {
+		"tls_cert": "localhost.crt",
+		"tls_key": "localhost.key",
+		"redirect_listen": ":8080"
+	}
+}
+END
+
+echo "Wrote localhost.crt, localhost.key and tls-test.json"
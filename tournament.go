@@ -0,0 +1,486 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Tournaments, for more people than fit around one table. Any lobby can
+// become a tournament's hall, where people sign up:
+//
+//	tournament create      (host) start taking sign-ups
+//	tournament register    sign up (or "unregister" to drop out)
+//	tournament start       (host) draw up the first round
+//	tournament bracket     see how it's going
+//
+// Each round, the players are split into tables of tournament.table_size, with
+// whoever is left over at one more table, or given a bye if that's just one
+// person. Tables are lobbies of their own, and each player is sent
+// "tournament_table <lobby>" so they can go there. They're sat down as they
+// arrive, and the game starts once everyone is there, or after
+// tournament.no_show_wait with whoever has turned up.
+// The top tournament.advance players from each table go through to the next
+// round, along with anyone who had a bye, until there's only one left.
+//
+// Everyone in the hall and at the tables is sent "bracket <json>" whenever
+// anything changes, and "tournament_winner <name>" at the end. The same JSON
+// can be fetched from /tournament?name=<hall>.
+
+type Tournament struct {
+	mu sync.Mutex
+
+	Name    string   `json:"name"`
+	State   string   `json:"state"` // registering, playing or finished
+	Players []string `json:"players"`
+	Rounds  []*round `json:"rounds"`
+	Winner  string   `json:"winner,omitempty"`
+
+	hall    *Lobby // Might be nil, if everyone has gone off to their tables
+	lobbies map[string]*Lobby
+	at      map[string]*Lobby // Where each player was last seen
+}
+
+type round struct {
+	Tables []*table  `json:"tables"`
+	Byes   []string `json:"byes,omitempty"`
+}
+
+type table struct {
+	Lobby    string   `json:"lobby"`
+	Seats    []string `json:"seats"`
+	State    string   `json:"state"` // waiting, playing or finished
+	Placings []string `json:"placings,omitempty"`
+	Advanced []string `json:"advanced,omitempty"`
+	NoShows  []string `json:"no_shows,omitempty"`
+
+	lobby *Lobby
+	round *round
+}
+
+// Every tournament, by the name of its hall
+var tournaments = make(map[string]*Tournament)
+var tournamentsMu sync.Mutex
+
+func findTournament(name string) *Tournament {
+	tournamentsMu.Lock()
+	defer tournamentsMu.Unlock()
+	return tournaments[name]
+}
+
+func (l *Lobby) getTournament() *Tournament {
+	l.clientsMu.Lock()
+	defer l.clientsMu.Unlock()
+	return l.tournament
+}
+
+func (l *Lobby) readTournament(c *Client, fields []string) {
+	if len(fields) != 2 {
+		c.sendMsg("notice usage_tournament")
+		return
+	}
+
+	if fields[1] == "create" {
+		l.createTournament(c)
+		return
+	}
+
+	t := l.getTournament()
+	if t == nil {
+		c.sendMsg("notice no_tournament")
+		return
+	}
+	t.command(l, c, fields[1])
+}
+
+func (l *Lobby) createTournament(c *Client) {
+	l.clientsMu.Lock()
+	isHost := c == l.host
+	existing := l.tournament
+	l.clientsMu.Unlock()
+
+	if !isHost {
+		c.sendMsg("notice not_host")
+		return
+	}
+	if existing != nil && !existing.finished() {
+		c.sendMsg("notice tournament_exists")
+		return
+	}
+
+	tournamentsMu.Lock()
+	if other := tournaments[l.name]; other != nil && other != existing {
+		// (A finished one here can only be this lobby's, to be replaced)
+		tournamentsMu.Unlock()
+		c.sendMsg("notice tournament_exists")
+		return
+	}
+	t := &Tournament{
+		Name:    l.name,
+		State:   "registering",
+		hall:    l,
+		lobbies: l.lobbies,
+		at:      make(map[string]*Lobby),
+	}
+	tournaments[l.name] = t
+	tournamentsMu.Unlock()
+
+	l.clientsMu.Lock()
+	l.tournament = t
+	l.clientsMu.Unlock()
+
+	t.mu.Lock()
+	t.publish()
+	t.mu.Unlock()
+}
+
+func (t *Tournament) command(l *Lobby, c *Client, cmd string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch cmd {
+	case "register", "unregister":
+		if l != t.hall || t.State != "registering" {
+			c.sendMsg("notice registration_closed")
+			return
+		}
+
+		i := slices.Index(t.Players, c.name)
+		if cmd == "register" && i == -1 {
+			t.Players = append(t.Players, c.name)
+			t.at[c.name] = l
+		}
+		if cmd == "unregister" && i != -1 {
+			t.Players = slices.Delete(t.Players, i, i+1)
+		}
+		t.publish()
+
+	case "start":
+		l.clientsMu.Lock()
+		isHost := c == l.host
+		l.clientsMu.Unlock()
+
+		if l != t.hall || !isHost {
+			c.sendMsg("notice not_host")
+			return
+		}
+		if t.State != "registering" {
+			c.sendMsg("notice tournament_started")
+			return
+		}
+		if len(t.Players) < 2 {
+			c.sendMsg("notice tournament_players")
+			return
+		}
+
+		t.State = "playing"
+		t.nextRound(t.Players)
+
+	case "bracket":
+		c.sendMsg(t.bracket())
+
+	default:
+		c.sendMsg("notice usage_tournament")
+	}
+}
+
+func (t *Tournament) nextRound(players []string) {
+	// Splits the players up into tables, or finishes the tournament if
+	// there's nobody left to play against
+	// /!\ needs a lock on t.mu
+	if len(players) < 2 {
+		t.State = "finished"
+		if len(players) == 1 {
+			t.Winner = players[0]
+		}
+		t.publish()
+
+		if t.Winner != "" {
+			t.bcast("tournament_winner " + t.Winner)
+		}
+		if t.hall == nil {
+			// Nobody is left in the hall to look at it
+			t.forget()
+		}
+		return
+	}
+
+	r := &round{}
+	t.Rounds = append(t.Rounds, r)
+
+	shuffled := append([]string{}, players...)
+	rand.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+
+	// Full tables, and then whoever is left over
+	size := conf().Tournament.TableSize
+	for i := 0; i < len(shuffled); i += size {
+		names := shuffled[i:min(i+size, len(shuffled))]
+		if len(names) < 2 {
+			// Nobody to play against
+			r.Byes = append(r.Byes, names...)
+			continue
+		}
+		r.Tables = append(r.Tables, t.openTable(r, len(r.Tables)+1, names))
+	}
+
+	t.publish()
+
+	for _, tb := range r.Tables {
+		for _, name := range tb.Seats {
+			t.send(name, "tournament_table "+tb.Lobby)
+		}
+	}
+
+	time.AfterFunc(conf().Tournament.NoShowWait.Duration, func() {
+		t.noShows(r)
+	})
+}
+
+func (t *Tournament) openTable(r *round, number int, names []string) *table {
+	// /!\ needs a lock on t.mu
+	name := t.Name + "-r" + strconv.Itoa(len(t.Rounds)) + "t" + strconv.Itoa(number)
+	lobbiesMu.Lock()
+	for t.lobbies[name] != nil {
+		// Someone has got there first
+		name += "x"
+	}
+
+	tb := &table{Lobby: name, Seats: names, State: "waiting", round: r}
+
+	tb.lobby = newLobby(name)
+	tb.lobby.lobbies = t.lobbies
+	tb.lobby.tournament = t
+	tb.lobby.table = tb
+	tb.lobby.match.keepSeats = false
+	tb.lobby.allowPeeking = false
+	t.lobbies[name] = tb.lobby
+	lobbiesMu.Unlock()
+	go tb.lobby.run(t.lobbies)
+
+	return tb
+}
+
+func (t *Tournament) arrived(l *Lobby, c *Client) {
+	// Called when someone joins the hall or a table; sits them down if
+	// this is where they should be
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c.sendMsg(t.bracket())
+
+	if slices.Contains(t.Players, c.name) {
+		t.at[c.name] = l
+	}
+
+	tb := l.table
+	if tb == nil || tb.State != "waiting" || !slices.Contains(tb.Seats, c.name) {
+		return
+	}
+
+	g := l.currentGame
+	if g.started || g.playerNumber(c) != -1 {
+		return
+	}
+	g.upgradePlayer(c)
+
+	if len(g.players) == len(tb.Seats) {
+		t.startTable(tb)
+		t.publish()
+	}
+}
+
+func (t *Tournament) startTable(tb *table) {
+	// /!\ needs a lock on t.mu
+	tb.State = "playing"
+	tb.lobby.currentGame.start()
+}
+
+func (t *Tournament) noShows(r *round) {
+	// Starts any tables still waiting for people, without them
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, tb := range r.Tables {
+		if tb.State != "waiting" {
+			continue
+		}
+
+		g := tb.lobby.currentGame
+		for _, name := range tb.Seats {
+			if g.playerByName(name) == nil {
+				tb.NoShows = append(tb.NoShows, name)
+			}
+		}
+
+		if len(g.players) >= 2 {
+			t.startTable(tb)
+			continue
+		}
+
+		// Not enough for a game; anyone who did turn up goes through
+		placings := []string{}
+		for _, player := range g.players {
+			placings = append(placings, player.name)
+		}
+		t.finishTable(tb, placings)
+	}
+
+	t.publish()
+}
+
+func (t *Tournament) tableFinished(l *Lobby, placings []string) {
+	// Called when the game at a table has been won
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if l.table == nil || l.table.State != "playing" {
+		// Just a game for fun, after the real one
+		return
+	}
+
+	t.finishTable(l.table, placings)
+	t.publish()
+}
+
+func (t *Tournament) finishTable(tb *table, placings []string) {
+	// /!\ needs a lock on t.mu
+	tb.State = "finished"
+	tb.Placings = placings
+
+	if tb.lobby.clientCount() == 0 {
+		// Nobody came, so nobody will leave to close it
+		tb.lobby.closing <- true
+	}
+
+	// At least one player always goes out, so that the tournament ends
+	advance := conf().Tournament.Advance
+	if advance > len(placings)-1 {
+		advance = len(placings) - 1
+	}
+	if advance < 1 {
+		advance = 1
+	}
+	if advance > len(placings) {
+		advance = len(placings)
+	}
+	tb.Advanced = placings[:advance]
+
+	for _, other := range tb.round.Tables {
+		if other.State != "finished" {
+			return
+		}
+	}
+
+	// That's the round over
+	next := append([]string{}, tb.round.Byes...)
+	for _, other := range tb.round.Tables {
+		next = append(next, other.Advanced...)
+	}
+	t.nextRound(next)
+}
+
+func (t *Tournament) send(name string, msg string) {
+	// Sends a message to a player, wherever they are
+	// /!\ needs a lock on t.mu
+	for _, l := range []*Lobby{t.at[name], t.hall} {
+		if l == nil {
+			continue
+		}
+		if client := l.clientByName(name); client != nil {
+			client.sendMsg(msg)
+			return
+		}
+	}
+}
+
+func (t *Tournament) bcast(msg string) {
+	// Sends a message to the hall and every table
+	// /!\ needs a lock on t.mu
+	if t.hall != nil {
+		t.hall.sendBcast(msg)
+	}
+	for _, r := range t.Rounds {
+		for _, tb := range r.Tables {
+			tb.lobby.sendBcast(msg)
+		}
+	}
+}
+
+func (t *Tournament) publish() {
+	// /!\ needs a lock on t.mu
+	t.bcast(t.bracket())
+}
+
+func (t *Tournament) bracket() string {
+	// /!\ needs a lock on t.mu
+	data, _ := json.Marshal(t)
+	return "bracket " + string(data)
+}
+
+func (t *Tournament) reopen(l *Lobby) {
+	// Everyone left the hall, and now someone has come back to it
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.State == "finished" {
+		// It's all over; the hall is just a lobby again
+		return
+	}
+	t.hall = l
+
+	l.clientsMu.Lock()
+	l.tournament = t
+	l.clientsMu.Unlock()
+}
+
+func (t *Tournament) hallClosed(l *Lobby) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.hall != l {
+		return
+	}
+	t.hall = nil
+
+	if t.State != "playing" {
+		// Nothing more to see
+		t.forget()
+	}
+}
+
+func (t *Tournament) forget() {
+	// Takes it off the list, so the name can be used again
+	// /!\ needs a lock on t.mu
+	tournamentsMu.Lock()
+	defer tournamentsMu.Unlock()
+
+	if tournaments[t.Name] == t {
+		delete(tournaments, t.Name)
+	}
+}
+
+func (t *Tournament) finished() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.State == "finished"
+}
+
+func serveBracket(w http.ResponseWriter, r *http.Request) {
+	t := findTournament(r.URL.Query().Get("name"))
+	if t == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
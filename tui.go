@@ -0,0 +1,641 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// A full-screen client for the terminal, for anyone who'd rather not leave
+// it: wwwcats client [-lobby <lobby>] [-name <name>] <url>. It speaks the
+// same protocol as the web client, and takes the same input: anything
+// starting with "/" is a command ("/draw", "/play 12"), and anything else is
+// chat, or the answer to whatever question is on screen.
+//
+// It needs a Unix-ish terminal, as it uses stty to read keys as they come.
+
+type tui struct {
+	conn  *websocket.Conn
+	name  string
+	lobby string
+
+	// What's on screen
+	players    []string // As name:cards:state:turns
+	spectators []string
+	queue      []string
+	host       string
+	hand       []string // As id:card
+	discard    string
+	nowPlaying string
+	turnsLeft  string
+	cardsLeft  string
+	paused     bool
+	defusing   bool     // Waiting for us to play a defuse
+	question   []string // The "q" message being answered, if any
+	log        []string
+	input      []rune
+
+	rows, cols int
+
+	nextSeq   uint64
+	lastAcked uint64
+}
+
+// Messages which only change what's on screen, rather than going in the log
+var tuiStateOnly = map[string]bool{
+	"players":      true,
+	"player_state": true,
+	"spectators":   true,
+	"hand":         true,
+	"cards_left":   true,
+	"turns_left":   true,
+	"host":         true,
+	"message":      true,
+	"q":            true,
+}
+
+func terminalClient(args []string) {
+	fs := flag.NewFlagSet("client", flag.ExitOnError)
+	lobby := fs.String("lobby", "", "lobby to join (asked for if not given)")
+	name := fs.String("name", "", "name to play as (asked for if not given)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: wwwcats client [-lobby <lobby>] [-name <name>] <url>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	wsURL, cardsURL, err := clientURLs(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "bad url:", err)
+		os.Exit(2)
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	*lobby = ask(stdin, "Lobby", *lobby)
+	*name = ask(stdin, "Name", *name)
+
+	// The server might have its own cards
+	if err := fetchCards(cardsURL); err != nil {
+		fmt.Fprintln(os.Stderr, "couldn't fetch the cards, using the built-in ones:", err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "couldn't connect:", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	t := &tui{conn: conn, name: *name, lobby: *lobby, nextSeq: 1}
+	t.send("join_lobby " + t.lobby + " " + t.name)
+
+	restore, err := rawTerminal()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "couldn't set up the terminal:", err)
+		os.Exit(1)
+	}
+
+	reason := t.run(stdin)
+
+	restore()
+	if reason != "" {
+		fmt.Println(reason)
+	}
+}
+
+func clientURLs(arg string) (string, string, error) {
+	// The websocket to connect to and where the cards are, from whatever
+	// the user gave us: a page, a websocket, or just host:port
+	if !strings.Contains(arg, "://") {
+		arg = "http://" + arg
+	}
+	u, err := url.Parse(arg)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch u.Scheme {
+	case "http", "ws":
+		u.Scheme = "ws"
+	case "https", "wss":
+		u.Scheme = "wss"
+	default:
+		return "", "", fmt.Errorf("unknown scheme %q", u.Scheme)
+	}
+
+	base := strings.TrimSuffix(strings.TrimSuffix(u.Path, "ws"), "/")
+	u.Path = base + "/ws"
+	ws := u.String()
+
+	if u.Scheme == "wss" {
+		u.Scheme = "https"
+	} else {
+		u.Scheme = "http"
+	}
+	u.Path = base + "/cards.json"
+	return ws, u.String(), nil
+}
+
+func ask(stdin *bufio.Reader, what string, given string) string {
+	for given == "" || strings.ContainsAny(given, " \t") {
+		fmt.Print(what + " (one word): ")
+		line, err := stdin.ReadString('\n')
+		if err != nil {
+			os.Exit(1)
+		}
+		given = strings.TrimSpace(line)
+	}
+	return given
+}
+
+func fetchCards(cardsURL string) error {
+	client := http.Client{Timeout: 10 * time.Second}
+	res, err := client.Get(cardsURL)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", res.Status)
+	}
+
+	var types []*CardType
+	if err := json.NewDecoder(res.Body).Decode(&types); err != nil {
+		return err
+	}
+	registry, err := newCardRegistry(types)
+	if err != nil {
+		return err
+	}
+	cardTypes = registry
+	return nil
+}
+
+func rawTerminal() (func(), error) {
+	// Reads keys as they're pressed, without echoing them, on a screen of
+	// our own; returns how to put everything back
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		return nil, err
+	}
+	fmt.Print("\x1b[?1049h")
+
+	return func() {
+		fmt.Print("\x1b[?1049l")
+		stty(strings.TrimSpace(saved))
+	}, nil
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+func terminalSize() (int, int) {
+	rows, cols := 24, 80
+	if out, err := stty("size"); err == nil {
+		fmt.Sscan(out, &rows, &cols)
+	}
+	return rows, cols
+}
+
+func (t *tui) run(stdin *bufio.Reader) string {
+	// Everything happens here, one thing at a time; returns why it stopped
+	msgs := make(chan string, 64)
+	go func() {
+		defer close(msgs)
+		for {
+			_, data, err := t.conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			msgs <- string(data)
+		}
+	}()
+
+	keys := make(chan rune, 64)
+	go func() {
+		defer close(keys)
+		for {
+			r, _, err := stdin.ReadRune()
+			if err != nil {
+				return
+			}
+			keys <- r
+		}
+	}()
+
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+
+	// There's no portable way to hear about resizes, so look every so often
+	resized := time.NewTicker(time.Second)
+	defer resized.Stop()
+
+	t.rows, t.cols = terminalSize()
+	t.draw()
+
+	escape := 0 // How far into an escape sequence we are
+	for {
+		select {
+		case line, ok := <-msgs:
+			if !ok {
+				return "The connection to the server was lost."
+			}
+			if reason := t.receive(line); reason != "" {
+				return reason
+			}
+
+		case r, ok := <-keys:
+			if !ok {
+				return ""
+			}
+
+			switch {
+			case escape == 1 && r == '[':
+				escape = 2
+				continue
+			case escape > 0:
+				// Arrow keys and so on; nothing to do with them
+				if escape == 1 || (r >= '@' && r <= '~') {
+					escape = 0
+				}
+				continue
+			}
+
+			switch r {
+			case '\x1b':
+				escape = 1
+			case '\r', '\n':
+				line := strings.TrimSpace(string(t.input))
+				t.input = nil
+				if quit := t.command(line); quit {
+					return ""
+				}
+			case '\x7f', '\b':
+				if len(t.input) > 0 {
+					t.input = t.input[:len(t.input)-1]
+				}
+			case '\x15': // Ctrl-U
+				t.input = nil
+			case '\x04': // Ctrl-D
+				if len(t.input) == 0 {
+					t.send("quit")
+					return ""
+				}
+			default:
+				if r >= ' ' {
+					t.input = append(t.input, r)
+				}
+			}
+
+		case <-resized.C:
+			rows, cols := terminalSize()
+			if rows == t.rows && cols == t.cols {
+				continue
+			}
+			t.rows, t.cols = rows, cols
+
+		case <-interrupted:
+			t.send("quit")
+			return ""
+		}
+
+		t.draw()
+	}
+}
+
+func (t *tui) send(msg string) {
+	t.conn.WriteMessage(websocket.TextMessage, []byte(msg))
+}
+
+func (t *tui) command(line string) bool {
+	// Deals with a line of input; returns true if it's time to go
+	switch {
+	case line == "":
+		return false
+
+	case strings.HasPrefix(line, "/"):
+		fields := strings.Fields(line[1:])
+		if len(fields) == 0 {
+			return false
+		}
+
+		switch fields[0] {
+		case "help":
+			t.addLog("Commands (type them with a / in front):")
+			for _, help := range strings.Split(commandHelp, "\n") {
+				t.addLog(help)
+			}
+			t.addLog("Anything else is chat, or the answer to the question on screen.")
+			return false
+		case "quit", "exit":
+			t.send("quit")
+			return true
+		case "play":
+			if len(fields) > 2 {
+				fields[0] = "play_multiple"
+			}
+		case "a":
+			t.question = nil
+		}
+		t.send(strings.Join(fields, " "))
+
+	case t.question != nil:
+		t.send("a " + t.question[0] + " " + line)
+		t.question = nil
+
+	default:
+		t.send("chat " + line)
+	}
+	return false
+}
+
+func (t *tui) receive(line string) string {
+	// Deals with a message from the server; returns why to stop, if it's
+	// time to
+	// Whatever the server lets through, nobody gets to send our terminal
+	// escape codes
+	line = strings.Map(func(r rune) rune {
+		if isControl(r) {
+			return -1
+		}
+		return r
+	}, line)
+
+	seqText, msg, _ := strings.Cut(line, " ")
+	if seq, err := strconv.ParseUint(seqText, 10, 64); err == nil {
+		t.nextSeq = seq + 1
+	}
+	if t.nextSeq-1-t.lastAcked >= 20 {
+		// So the server can forget what we've seen
+		t.lastAcked = t.nextSeq - 1
+		t.send("ack " + strconv.FormatUint(t.lastAcked, 10))
+	}
+
+	f := strings.Fields(msg)
+	if len(f) == 0 {
+		return ""
+	}
+
+	switch f[0] {
+	case "version":
+		if len(f) != 2 || f[1] != strconv.Itoa(REVISION) {
+			return "The server is a different version (" + strings.Join(f[1:], " ") +
+				", and this is " + strconv.Itoa(REVISION) + ")."
+		}
+	case "err":
+		if len(f) == 2 && f[1] == "illegal_move" {
+			// Easily done when typing, and nothing to leave over
+			break
+		}
+		return "The server said: " + describe(msg)
+	case "players":
+		t.players = f[1:]
+	case "player_state":
+		if len(f) == 2 {
+			name, _, _ := strings.Cut(f[1], ":")
+			for i, player := range t.players {
+				if strings.HasPrefix(player, name+":") {
+					t.players[i] = f[1]
+				}
+			}
+		}
+	case "spectators":
+		t.spectators = f[1:]
+	case "joins", "downgrades":
+		if len(f) == 2 && !slices.Contains(t.spectators, f[1]) {
+			t.spectators = append(t.spectators, f[1])
+		}
+	case "parts", "upgrades":
+		if len(f) == 2 {
+			if i := slices.Index(t.spectators, f[1]); i != -1 {
+				t.spectators = slices.Delete(t.spectators, i, i+1)
+			}
+		}
+	case "queue":
+		t.queue = f[1:]
+	case "host":
+		t.host = strings.Join(f[1:], " ")
+	case "hand":
+		t.hand = f[1:]
+		if t.defusing && !t.hasDefuse() {
+			t.defusing = false
+		}
+	case "discard":
+		t.discard = strings.Join(f[1:], " ")
+	case "played":
+		if len(f) == 3 {
+			t.discard = f[2]
+		}
+	case "played_multiple":
+		if len(f) == 4 {
+			t.discard = f[3]
+		}
+	case "no_discard":
+		t.discard = ""
+	case "now_playing":
+		t.nowPlaying = strings.Join(f[1:], " ")
+	case "defusing":
+		t.defusing = true
+	case "exploded":
+		t.defusing = false
+	case "turns_left":
+		t.turnsLeft = strings.Join(f[1:], " ")
+	case "cards_left":
+		t.cardsLeft = strings.Join(f[1:], " ")
+	case "paused", "unpaused":
+		t.paused = f[0] == "paused"
+	case "q":
+		t.question = f[1:]
+		if len(f) > 1 && f[1] == "defuse_pos" {
+			t.defusing = false
+		}
+	case "q_cancel", "wins":
+		t.question = nil
+	case "bcast":
+		if len(f) == 2 && f[1] == "new_game" {
+			t.hand, t.discard, t.nowPlaying, t.turnsLeft, t.cardsLeft = nil, "", "", "", ""
+			t.question = nil
+			t.defusing = false
+		}
+	}
+
+	if !tuiStateOnly[f[0]] {
+		if description := describe(msg); description != "" {
+			t.addLog(description)
+		}
+	}
+	return ""
+}
+
+func (t *tui) hasDefuse() bool {
+	for _, card := range t.hand {
+		_, name, _ := strings.Cut(card, ":")
+		if cardTypes.effect(name) == effectDefuse {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *tui) addLog(line string) {
+	t.log = append(t.log, line)
+	if len(t.log) > 500 {
+		t.log = t.log[len(t.log)-500:]
+	}
+}
+
+func (t *tui) draw() {
+	// Redraws the whole screen
+	width := t.cols
+	var lines []string
+
+	title := " wwwcats: " + t.lobby + " as " + t.name
+	if t.host != "" {
+		title += " | host " + t.host
+	}
+	if t.cardsLeft != "" {
+		title += " | " + t.cardsLeft + " cards left"
+	}
+	if t.discard != "" {
+		title += " | discard " + describeCard(t.discard)
+	}
+	if t.paused {
+		title += " | PAUSED"
+	}
+	lines = append(lines, "\x1b[7m"+pad(title, width)+"\x1b[0m")
+
+	var players []string
+	for _, state := range t.players {
+		parts := strings.Split(state, ":")
+		if len(parts) != 4 {
+			continue
+		}
+		player := parts[0] + " (" + parts[1] + ", " + parts[2] + ")"
+		if parts[0] == t.nowPlaying {
+			player = ">" + player
+			if t.turnsLeft != "" && t.turnsLeft != "1" {
+				player += " x" + t.turnsLeft
+			}
+		}
+		players = append(players, player)
+	}
+	lines = append(lines, clip("Players: "+strings.Join(players, "  "), width))
+
+	watching := "Watching: " + strings.Join(t.spectators, " ")
+	if len(t.queue) > 0 {
+		watching += "  Queue: " + strings.Join(t.queue, " ")
+	}
+	lines = append(lines, clip(watching, width))
+
+	var hand []string
+	for _, card := range t.hand {
+		hand = append(hand, describeCard(card))
+	}
+	lines = append(lines, wrap("Your hand: "+strings.Join(hand, ", "), width)...)
+	lines = append(lines, strings.Repeat("-", width))
+
+	// The log fills the rest, apart from the question and input lines
+	room := max(t.rows-len(lines)-2, 0)
+	var logLines []string
+	for _, line := range t.log {
+		logLines = append(logLines, wrap(line, width)...)
+	}
+	if len(logLines) > room {
+		logLines = logLines[len(logLines)-room:]
+	}
+	lines = append(lines, logLines...)
+	for len(lines) < t.rows-2 {
+		lines = append(lines, "")
+	}
+
+	prompt := "/help for commands; anything else is chat"
+	if t.question != nil {
+		prompt = describe("q " + strings.Join(t.question, " "))
+		if prompt == "" {
+			prompt = "Answer: " + strings.Join(t.question, " ")
+		}
+		prompt = "\x1b[33m" + clip(prompt, width) + "\x1b[0m"
+	} else if t.defusing {
+		prompt = "\x1b[33m" + clip("You drew a Detonating Cat! Play a Defuse: /play <id>", width) + "\x1b[0m"
+	}
+	lines = append(lines, prompt)
+
+	input := "> " + string(t.input)
+	if len([]rune(input)) >= width {
+		// Keep the end in view
+		runes := []rune(input)
+		input = string(runes[len(runes)-width+1:])
+	}
+	lines = append(lines, input)
+
+	var screen strings.Builder
+	screen.WriteString("\x1b[H")
+	for i, line := range lines {
+		if i > 0 {
+			screen.WriteString("\r\n")
+		}
+		screen.WriteString(line + "\x1b[K")
+	}
+	fmt.Print(screen.String())
+}
+
+func clip(line string, width int) string {
+	runes := []rune(line)
+	if len(runes) > width {
+		return string(runes[:width])
+	}
+	return line
+}
+
+func pad(line string, width int) string {
+	line = clip(line, width)
+	return line + strings.Repeat(" ", width-len([]rune(line)))
+}
+
+func wrap(line string, width int) []string {
+	runes := []rune(line)
+	if width < 1 || len(runes) <= width {
+		return []string{line}
+	}
+
+	var lines []string
+	for len(runes) > width {
+		// At a space, if there is one
+		cut := width
+		for i := width; i > width/2; i-- {
+			if runes[i] == ' ' {
+				cut = i
+				break
+			}
+		}
+		lines = append(lines, string(runes[:cut]))
+		runes = []rune(strings.TrimLeft(string(runes[cut:]), " "))
+	}
+	return append(lines, string(runes))
+}
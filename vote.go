@@ -0,0 +1,264 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// Things the players decide together, by majority:
+//
+//	pause             stop the game for a while
+//	unpause           carry on
+//	vote_kick <name>  throw someone out of the game, and the lobby
+//	vote yes|no
+//
+// Whoever calls a vote is counted as voting yes. A kick needs at least two
+// votes for it, so it takes three players or more. Everyone sees
+// "vote_started <kind> <by> [<target>]", then "vote_count <yes> <no> <needed>"
+// as votes come in, and finally "vote_passed <kind> [<target>]" or
+// "vote_failed <kind> [<target>]", or "vote_expired <kind> [<target>]" if it
+// hasn't been decided within game.vote_timeout. Only one vote can run at a
+// time.
+
+type vote struct {
+	id     uint64
+	kind   string // pause, unpause or kick
+	by     *Client
+	target *Client
+	votes  map[*Client]bool
+	timer  *time.Timer
+}
+
+// A vote that has run out of time, for the lobby's goroutine to give up on
+type voteEnd struct {
+	game *Game
+	id   uint64
+}
+
+// Game moves, which nobody can make while the game is paused
+var pausedMoves = map[string]bool{
+	"draw":          true,
+	"play":          true,
+	"play_multiple": true,
+	"a":             true,
+}
+
+func (g *Game) callVote(c *Client, kind string, target string) {
+	if g.playerNumber(c) == -1 {
+		// Spectators don't get a say
+		return
+	}
+
+	v := &vote{kind: kind, by: c, votes: make(map[*Client]bool)}
+
+	switch kind {
+	case "pause", "unpause":
+		if !g.started || g.paused == (kind == "pause") {
+			c.sendMsg("notice cant_" + kind)
+			return
+		}
+
+	case "kick":
+		v.target = g.playerByName(target)
+		if v.target == nil || v.target == c || len(g.players) < 3 {
+			c.sendMsg("notice cant_kick")
+			return
+		}
+	}
+
+	g.lobby.clientsMu.Lock()
+	if g.vote != nil {
+		g.lobby.clientsMu.Unlock()
+		c.sendMsg("notice vote_running")
+		return
+	}
+	g.voteID++
+	v.id = g.voteID
+	end := voteEnd{g, v.id}
+	v.timer = time.AfterFunc(conf().Game.VoteTimeout.Duration, func() {
+		// Given up on from the lobby's goroutine, not this one
+		g.lobby.voteEnds <- end
+	})
+	g.vote = v
+	g.lobby.clientsMu.Unlock()
+
+	g.lobby.sendBcast(v.started())
+
+	g.castVote(c, true)
+}
+
+func (g *Game) castVote(c *Client, yes bool) {
+	g.lobby.clientsMu.Lock()
+	v := g.vote
+	counted := v != nil && g.playerNumber(c) != -1 && c != v.target
+	if counted {
+		v.votes[c] = yes
+	}
+	g.lobby.clientsMu.Unlock()
+
+	if counted {
+		g.countVotes()
+	}
+}
+
+func (g *Game) countVotes() {
+	// Decides the vote if it can be decided yet; called whenever someone
+	// votes, or leaves
+	g.lobby.clientsMu.Lock()
+	v := g.vote
+	if v == nil {
+		g.lobby.clientsMu.Unlock()
+		return
+	}
+	yes, no, voters := g.tally()
+	count := g.voteCount()
+	g.lobby.clientsMu.Unlock()
+
+	if v.target != nil && g.playerNumber(v.target) == -1 {
+		// They've gone anyway
+		if g.endVote(v) {
+			g.lobby.sendBcast("vote_failed " + v.what())
+		}
+		return
+	}
+
+	needed := v.needed(voters)
+	g.lobby.sendBcast(count)
+
+	switch {
+	case yes >= needed:
+		if g.endVote(v) {
+			g.lobby.sendBcast("vote_passed " + v.what())
+			g.votePassed(v)
+		}
+
+	case voters-no < needed:
+		// There aren't enough votes left for it to pass
+		if g.endVote(v) {
+			g.lobby.sendBcast("vote_failed " + v.what())
+		}
+	}
+}
+
+func (g *Game) endVote(v *vote) bool {
+	// Closes the vote, unless something else has got there first
+	g.lobby.clientsMu.Lock()
+	defer g.lobby.clientsMu.Unlock()
+
+	if g.vote != v {
+		return false
+	}
+	v.timer.Stop()
+	g.vote = nil
+	return true
+}
+
+func (g *Game) voteExpired(id uint64) {
+	// Called from the lobby's goroutine
+	g.lobby.clientsMu.Lock()
+	v := g.vote
+	g.lobby.clientsMu.Unlock()
+
+	if v == nil || v.id != id || !g.endVote(v) {
+		// Decided just as time ran out
+		return
+	}
+	g.lobby.sendBcast("vote_expired " + v.what())
+}
+
+func (g *Game) tally() (yes int, no int, voters int) {
+	// Counts the votes of everyone still playing
+	// /!\ needs a lock on g.lobby.clients
+	for client, vote := range g.vote.votes {
+		if g.playerNumber(client) == -1 {
+			continue
+		}
+		if vote {
+			yes++
+		} else {
+			no++
+		}
+	}
+
+	// The person being kicked doesn't get a vote
+	voters = len(g.players)
+	if g.vote.target != nil {
+		voters--
+	}
+	return
+}
+
+func (g *Game) voteCount() string {
+	// /!\ needs a lock on g.lobby.clients
+	yes, no, voters := g.tally()
+	return "vote_count " + strconv.Itoa(yes) + " " + strconv.Itoa(no) + " " + strconv.Itoa(g.vote.needed(voters))
+}
+
+func (v *vote) needed(voters int) int {
+	// A majority, and for a kick never just the caller on their own
+	if v.kind == "kick" {
+		return max(voters/2+1, 2)
+	}
+	return voters/2 + 1
+}
+
+func (v *vote) what() string {
+	// The kind of vote, and who it's about
+	if v.target != nil {
+		return v.kind + " " + v.target.name
+	}
+	return v.kind
+}
+
+func (v *vote) started() string {
+	if v.target != nil {
+		return "vote_started kick " + v.by.name + " " + v.target.name
+	}
+	return "vote_started " + v.kind + " " + v.by.name
+}
+
+func (g *Game) votePassed(v *vote) {
+	switch v.kind {
+	case "pause":
+		g.paused = true
+		g.lobby.sendBcast("paused")
+	case "unpause":
+		g.paused = false
+		g.lobby.sendBcast("unpaused")
+	case "kick":
+		// They can't come back into this lobby under that name
+		g.lobby.ban(v.target.name)
+		g.downgradePlayer(v.target)
+		v.target.kick("kicked")
+	}
+}
+
+func (l *Lobby) ban(name string) {
+	l.clientsMu.Lock()
+	defer l.clientsMu.Unlock()
+
+	if l.banned == nil {
+		l.banned = make(map[string]bool)
+	}
+	l.banned[name] = true
+}
+
+func (l *Lobby) isBanned(name string) bool {
+	l.clientsMu.Lock()
+	defer l.clientsMu.Unlock()
+
+	return l.banned[name]
+}
+
+func (g *Game) sendVote(send func(string)) {
+	// Tells a newly joining client about any vote in progress
+	// /!\ needs a lock on g.lobby.clients
+	if g.paused {
+		send("paused")
+	}
+
+	if g.vote != nil {
+		send(g.vote.started())
+		send(g.voteCount())
+	}
+}
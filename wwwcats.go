@@ -1,54 +1,142 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"sync/atomic"
 
 	"github.com/gorilla/websocket"
 )
 
 var addr = flag.String("l", ":8080", "http service address")
+var staticDir = flag.String("static", "", "serve the client from this directory instead of the built-in copy (for development)")
+var configPath = flag.String("config", "", "settings file (JSON)")
+var cardsPath = flag.String("cards", "", "JSON file or directory of card definitions (default: built-in deck)")
 
 var REVISION = 9
 
+// For telling clients apart in the logs
+var lastClientID uint64
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		simulate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "client" {
+		terminalClient(os.Args[2:])
+		return
+	}
+
 	flag.Parse()
 
+	c, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatal("Bad settings: ", err)
+	}
+
+	if c.Server.Cards != "" {
+		registry, err := loadCardRegistry(c.Server.Cards)
+		if err != nil {
+			log.Fatal("Couldn't load cards: ", err)
+		}
+		cardTypes = registry
+	}
+	if err := c.checkCards(cardTypes); err != nil {
+		log.Fatal("Bad settings: ", err)
+	}
+
+	config.Store(c)
+	go reloadOnHangup(*configPath)
+
+	if err := setupLogging(c); err != nil {
+		log.Fatal("Couldn't set up logging: ", err)
+	}
+
+	upgrader.ReadBufferSize = c.Websocket.ReadBufferSize
+	upgrader.WriteBufferSize = c.Websocket.WriteBufferSize
+	upgrader.CheckOrigin = checkOrigin(c.Server.AllowedOrigins)
+
+	// Already checked by loadConfig
+	trustedProxies, _ = parseNetworks(c.Server.TrustedProxies)
+	base := c.Server.BasePath
+
 	// Create a global list of lobbies
 	lobbies := make(map[string]*Lobby)
 
 	// Serve the client-side software
-	fs := http.FileServer(http.Dir("public_html"))
-	http.Handle("/", fs)
+	static, err := newStaticFiles(c.Server.StaticDir)
+	if err != nil {
+		log.Fatal("Couldn't find the client: ", err)
+	}
+	http.Handle(base+"/", http.StripPrefix(base, static))
+	if base != "" {
+		// The client uses relative links, so needs the slash on the end
+		http.Handle(base, http.RedirectHandler(base+"/", http.StatusMovedPermanently))
+	}
+
+	// The client needs to know about the cards in use
+	http.HandleFunc(base+"/cards.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cardTypes.types)
+	})
+
+	// Live tournament brackets
+	http.HandleFunc(base+"/tournament", serveBracket)
+
+	// Game events for stream overlays
+	http.HandleFunc(base+"/overlay", func(w http.ResponseWriter, r *http.Request) {
+		serveOverlay(w, r, lobbies)
+	})
 
 	// Handle incoming websocket connections
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc(base+"/ws", func(w http.ResponseWriter, r *http.Request) {
 		handleConnections(w, r, lobbies)
 	})
 
+	// ... and the same over plain HTTP, where websockets are blocked
+	http.HandleFunc(base+"/sse", func(w http.ResponseWriter, r *http.Request) {
+		serveSSE(w, r, lobbies)
+	})
+
+	// Terminals, if wanted
+	if c.Server.TCPListen != "" {
+		go listenTCP(c.Server.TCPListen, lobbies)
+	}
+
 	// Start the server
-	log.Println("Now listening on", *addr)
-	log.Fatal(http.ListenAndServe(*addr, nil))
+	slog.Info("Now listening", "listen", c.Server.Listen)
+	if c.Server.TLSCert != "" {
+		log.Fatal(listenAndServeTLS(c, nil))
+	}
+	log.Fatal(http.ListenAndServe(c.Server.Listen, nil))
 }
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-}
+// Buffer sizes and origin checking are filled in from the settings
+var upgrader = websocket.Upgrader{}
 
 // Upgrade incoming connections to websockets
 func handleConnections(w http.ResponseWriter, r *http.Request, lobbies map[string]*Lobby) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println(err)
+		slog.Warn("Couldn't upgrade connection", "addr", clientAddr(r), "err", err)
 		return
 	}
 
 	// Instantiate the new client object
-	client := &Client{conn: conn, send: make(chan []byte, 256)}
+	client := &Client{
+		conn: conn,
+		send: make(chan []byte, conf().Websocket.SendBuffer),
+		addr: clientAddr(r),
+		id:   atomic.AddUint64(&lastClientID, 1),
+	}
+	client.log().Info("New connection", "addr", client.addr, "scheme", requestScheme(r))
 
 	// Hand the client off to these goroutines which will handle all i/o
-	go client.readPump(lobbies)
-	go client.writePump()
+	go client.readPump(conn, lobbies)
+	go client.writePump(conn, client.send)
 }